@@ -2,7 +2,11 @@ module github.com/sentinelabs/vertex-synapse-grafana
 
 go 1.21
 
-require github.com/grafana/grafana-plugin-sdk-go v0.196.0
+require (
+	github.com/grafana/grafana-plugin-sdk-go v0.196.0
+	go.opentelemetry.io/otel v1.21.0
+	golang.org/x/oauth2 v0.15.0
+)
 
 require (
 	github.com/BurntSushi/toml v1.3.2 // indirect
@@ -67,7 +71,6 @@ require (
 	go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace v0.46.1 // indirect
 	go.opentelemetry.io/contrib/propagators/jaeger v1.21.1 // indirect
 	go.opentelemetry.io/contrib/samplers/jaegerremote v0.15.1 // indirect
-	go.opentelemetry.io/otel v1.21.0 // indirect
 	go.opentelemetry.io/otel/exporters/otlp/otlptrace v1.21.0 // indirect
 	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.21.0 // indirect
 	go.opentelemetry.io/otel/metric v1.21.0 // indirect
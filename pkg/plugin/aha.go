@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultCortexHTTPSPort is the Cortex HTTPS API port used when an AHA
+// service entry does not advertise one
+const defaultCortexHTTPSPort = 4443
+
+// ahaResolver resolves the Cortex HTTP endpoint from an AHA service registry
+// and caches the result until it is invalidated
+type ahaResolver struct {
+	ahaURL     string
+	cortexName string
+	httpClient *httpClientWrapper
+
+	mu       sync.Mutex
+	resolved string
+}
+
+// ahaService is the subset of an AHA service entry needed for resolution
+type ahaService struct {
+	Name    string `json:"name"`
+	SvcInfo struct {
+		URLInfo struct {
+			Host string `json:"host"`
+		} `json:"urlinfo"`
+		HTTPSPort int `json:"https:port"`
+	} `json:"svcinfo"`
+}
+
+// baseURL returns the cached Cortex URL, resolving it via AHA when needed
+func (r *ahaResolver) baseURL(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.resolved != "" {
+		return r.resolved, nil
+	}
+
+	url := fmt.Sprintf("%s/api/v1/aha/services", strings.TrimSuffix(r.ahaURL, "/"))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("create aha request: %w", err)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("execute aha request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("aha service lookup failed with status: %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Status string       `json:"status"`
+		Result []ahaService `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("decode aha response: %w", err)
+	}
+
+	// Accept either the full service name or the leader/short name
+	for _, svc := range response.Result {
+		if svc.Name != r.cortexName && !strings.HasPrefix(svc.Name, r.cortexName+".") {
+			continue
+		}
+		if svc.SvcInfo.URLInfo.Host == "" {
+			continue
+		}
+		port := svc.SvcInfo.HTTPSPort
+		if port == 0 {
+			port = defaultCortexHTTPSPort
+		}
+		r.resolved = fmt.Sprintf("https://%s:%d", svc.SvcInfo.URLInfo.Host, port)
+		return r.resolved, nil
+	}
+
+	return "", fmt.Errorf("cortex %q not found in aha services", r.cortexName)
+}
+
+// invalidate drops the cached resolution so the next request re-resolves
+func (r *ahaResolver) invalidate() {
+	r.mu.Lock()
+	r.resolved = ""
+	r.mu.Unlock()
+}
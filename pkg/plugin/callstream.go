@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// streamedList stands in the decoded envelope for a result list that was
+// parsed while it was read
+type streamedList struct{}
+
+// decodeCallResponse decodes a storm/call response object from r. When the
+// envelope status is "ok" and precedes the result, a result list is parsed an
+// element at a time straight from r and its frames are returned along with
+// the envelope, holding a streamedList as the result. Otherwise the envelope
+// is decoded whole and the frames are nil.
+func (d *Datasource) decodeCallResponse(r io.Reader, qm QueryModel, streamable bool, refID string) (map[string]interface{}, data.Frames, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	tok, err := decoder.Token()
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode response: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil, unexpectedCallResponse(decoder, tok)
+	}
+
+	statusKey, resultKey, _ := d.envelopeKeys()
+	response := make(map[string]interface{})
+	var frames data.Frames
+	for decoder.More() {
+		keyTok, err := decoder.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode response: %w", err)
+		}
+		key, _ := keyTok.(string)
+
+		if key == resultKey && streamable && response[statusKey] == "ok" {
+			tok, err := decoder.Token()
+			if err != nil {
+				return nil, nil, fmt.Errorf("decode response: %w", err)
+			}
+			if delim, ok := tok.(json.Delim); ok && delim == '[' {
+				if frames, err = d.parseListStream(decoder, qm, refID); err != nil {
+					return nil, nil, err
+				}
+				response[key] = streamedList{}
+				continue
+			}
+			val, err := decodeTokenValue(decoder, tok)
+			if err != nil {
+				return nil, nil, fmt.Errorf("decode response: %w", err)
+			}
+			response[key] = val
+			continue
+		}
+
+		var val interface{}
+		if err := decoder.Decode(&val); err != nil {
+			return nil, nil, fmt.Errorf("decode response: %w", err)
+		}
+		response[key] = val
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, fmt.Errorf("decode response: %w", err)
+	}
+	return response, frames, nil
+}
+
+// unexpectedCallResponse describes a storm/call response that is not an
+// object starting with tok. A leading [type, info] message means the endpoint
+// is streaming storm messages rather than returning a single call result.
+func unexpectedCallResponse(decoder *json.Decoder, tok json.Token) error {
+	if delim, ok := tok.(json.Delim); ok && delim == '[' {
+		if msgTok, err := decoder.Token(); err == nil {
+			if msgType, isStr := msgTok.(string); isStr && (msgType == "init" || msgType == "node" || msgType == "fini") {
+				return fmt.Errorf("storm call returned a message stream instead of a single result; try disabling Use Call")
+			}
+		}
+		return fmt.Errorf("decode response: unexpected []interface {} result")
+	}
+	return fmt.Errorf("decode response: unexpected %T result", tok)
+}
+
+// decodeTokenValue decodes the rest of the JSON value starting with tok,
+// keeping numbers as json.Number
+func decodeTokenValue(decoder *json.Decoder, tok json.Token) (interface{}, error) {
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+
+	var val interface{}
+	switch delim {
+	case '{':
+		obj := make(map[string]interface{})
+		for decoder.More() {
+			keyTok, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := keyTok.(string)
+			var item interface{}
+			if err := decoder.Decode(&item); err != nil {
+				return nil, err
+			}
+			obj[key] = item
+		}
+		val = obj
+	case '[':
+		list := []interface{}{}
+		for decoder.More() {
+			var item interface{}
+			if err := decoder.Decode(&item); err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		val = list
+	default:
+		return nil, fmt.Errorf("unexpected %s", delim)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// parseListStream parses a result list whose opening bracket was just read
+// from decoder, decoding one element at a time and consuming the closing
+// bracket. Lists of objects are post filtered and added to the frame columns
+// as each element is decoded, and elements past the row cap are only
+// counted. Other lists are collected and parsed as usual.
+func (d *Datasource) parseListStream(decoder *json.Decoder, qm QueryModel, refID string) (data.Frames, error) {
+	if !decoder.More() {
+		if _, err := decoder.Token(); err != nil {
+			return nil, fmt.Errorf("decode result: %w", err)
+		}
+		return d.parseStormCallResult([]interface{}{}, qm, refID)
+	}
+
+	var first interface{}
+	if err := decoder.Decode(&first); err != nil {
+		return nil, fmt.Errorf("decode result: %w", err)
+	}
+	if _, ok := first.(map[string]interface{}); !ok {
+		items := []interface{}{first}
+		for decoder.More() {
+			var item interface{}
+			if err := decoder.Decode(&item); err != nil {
+				return nil, fmt.Errorf("decode result: %w", err)
+			}
+			items = append(items, item)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, fmt.Errorf("decode result: %w", err)
+		}
+		return d.parseStormCallResult(items, qm, refID)
+	}
+
+	maxRows, global := d.rowCap(qm)
+	filtered := len(qm.postFilters) > 0
+
+	cols := d.newObjectColumns(qm)
+	rows := 0
+	item := first
+	for {
+		if d.rowMatches(item, qm.postFilters) {
+			if obj, ok := item.(map[string]interface{}); ok && (maxRows <= 0 || rows < maxRows) {
+				d.addObject(cols, obj)
+			}
+			rows++
+		}
+		if !decoder.More() {
+			break
+		}
+
+		item = nil
+		if maxRows > 0 && rows >= maxRows && !filtered {
+			// Past the cap, elements are only counted, as the nil item
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return nil, fmt.Errorf("decode result: %w", err)
+			}
+			continue
+		}
+		if err := decoder.Decode(&item); err != nil {
+			return nil, fmt.Errorf("decode result: %w", err)
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("decode result: %w", err)
+	}
+
+	frames := d.objectFrames(cols, qm, refID)
+	if maxRows > 0 && rows > maxRows {
+		frames[0].AppendNotices(rowLimitNotice(maxRows, rows, global))
+	}
+	return frames, nil
+}
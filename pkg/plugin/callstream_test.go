@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// chunkedReader returns at most 1 to 3 bytes per read, like a slow network
+// body
+type chunkedReader struct {
+	data []byte
+	n    int
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	r.n = r.n%3 + 1
+	size := r.n
+	if size > len(p) {
+		size = len(p)
+	}
+	if size > len(r.data) {
+		size = len(r.data)
+	}
+	copy(p, r.data[:size])
+	r.data = r.data[size:]
+	return size, nil
+}
+
+func TestDecodeCallResponseStreamsResultList(t *testing.T) {
+	d := &Datasource{}
+	body := `{"status": "ok", "result": [{"name": "a", "count": 1}, {"name": "b", "count": 2}], "mesg": "done"}`
+
+	response, frames, err := d.decodeCallResponse(&chunkedReader{data: []byte(body)}, QueryModel{}, true, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := response["result"].(streamedList); !ok {
+		t.Fatalf("result not streamed: %#v", response["result"])
+	}
+	if response["mesg"] != "done" {
+		t.Errorf("mesg after the result = %v, want done", response["mesg"])
+	}
+	if len(frames) != 1 || frames[0].Rows() != 2 {
+		t.Fatalf("want one frame of 2 rows, got %v", frames)
+	}
+}
+
+func TestDecodeCallResponseBuffersUnstreamable(t *testing.T) {
+	d := &Datasource{}
+	for name, tc := range map[string]struct {
+		body       string
+		streamable bool
+	}{
+		"result before status": {`{"result": [{"a": 1}], "status": "ok"}`, true},
+		"not streamable":       {`{"status": "ok", "result": [{"a": 1}]}`, false},
+		"result not a list":    {`{"status": "ok", "result": {"a": 1}}`, true},
+	} {
+		response, frames, err := d.decodeCallResponse(strings.NewReader(tc.body), QueryModel{}, tc.streamable, "A")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if frames != nil {
+			t.Errorf("%s: unexpected streamed frames", name)
+		}
+		if _, ok := response["result"].(streamedList); ok {
+			t.Errorf("%s: result should be decoded whole", name)
+		}
+	}
+}
+
+func TestDecodeCallResponseMessageStream(t *testing.T) {
+	d := &Datasource{}
+	_, _, err := d.decodeCallResponse(strings.NewReader(`["init", {"tick": 1}]`), QueryModel{}, true, "A")
+	if err == nil || !strings.Contains(err.Error(), "message stream") {
+		t.Fatalf("want message stream error, got %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+package plugin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// baseURL returns the Cortex URL, resolved via AHA when configured
+func (d *Datasource) baseURL(ctx context.Context) (string, error) {
+	if d.aha == nil {
+		return d.settings.URL, nil
+	}
+	return d.aha.baseURL(ctx)
+}
+
+// doRequest executes req, dropping any cached AHA resolution on connection
+// failure so the next request re-resolves the Cortex
+func (d *Datasource) doRequest(req *http.Request) (*http.Response, error) {
+	resp, err := d.httpClient.Do(req)
+	if err != nil && d.aha != nil {
+		d.aha.invalidate()
+	}
+	return resp, err
+}
+
+// withTimeout derives a context bounded by timeoutMs, or by Config.Timeout
+// when timeoutMs is unset. Without either the context is left unbounded.
+func (d *Datasource) withTimeout(ctx context.Context, timeoutMs int) (context.Context, context.CancelFunc) {
+	if timeoutMs <= 0 {
+		timeoutMs = d.config.Timeout
+	}
+	if timeoutMs <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
+}
+
+// setContentHeaders applies the configured request and accept content types
+func (d *Datasource) setContentHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", d.config.RequestContentType)
+	if d.config.AcceptContentType != "" {
+		req.Header.Set("Accept", d.config.AcceptContentType)
+	}
+}
+
+// requestBody builds a Storm request body from Config.RequestBodyTemplate,
+// with the JSON encoded query and opts in place of ${query} and ${opts}, or
+// as {"query": ..., "opts": ...} by default. Nil opts are left out of the
+// default body.
+func (d *Datasource) requestBody(query string, opts map[string]interface{}) ([]byte, error) {
+	if d.config.RequestBodyTemplate == "" {
+		body := map[string]interface{}{"query": query}
+		if opts != nil {
+			body["opts"] = opts
+		}
+		reqBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, optsMarshalError(opts, err)
+		}
+		return reqBody, nil
+	}
+
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return nil, optsMarshalError(opts, err)
+	}
+
+	// Placeholders may be quoted to keep the template valid JSON
+	reqBody := strings.NewReplacer(
+		`"${query}"`, string(queryJSON),
+		`"${opts}"`, string(optsJSON),
+		"${query}", string(queryJSON),
+		"${opts}", string(optsJSON),
+	).Replace(d.config.RequestBodyTemplate)
+	if !json.Valid([]byte(reqBody)) {
+		return nil, fmt.Errorf("request body template does not produce valid JSON")
+	}
+	return []byte(reqBody), nil
+}
+
+// candidateURLs lists the Cortex base URLs to try in order: the last URL that
+// connected successfully, then the primary URL, then each fallback URL
+func (d *Datasource) candidateURLs(ctx context.Context) ([]string, error) {
+	var urls []string
+	primary, err := d.baseURL(ctx)
+	if err != nil {
+		if len(d.config.FallbackURLs) == 0 {
+			return nil, fmt.Errorf("resolve cortex url: %w", err)
+		}
+		log.DefaultLogger.Warn("Error resolving primary Cortex URL, using fallbacks", "error", err)
+	} else {
+		urls = append(urls, primary)
+	}
+	for _, fallback := range d.config.FallbackURLs {
+		urls = append(urls, strings.TrimSuffix(fallback, "/"))
+	}
+
+	d.urlMu.Lock()
+	lastGood := d.lastGoodURL
+	d.urlMu.Unlock()
+
+	// Try the last good URL first while it is still a candidate
+	ordered := make([]string, 0, len(urls))
+	if lastGood != "" && contains(urls, lastGood) {
+		ordered = append(ordered, lastGood)
+	}
+	for _, url := range urls {
+		if !contains(ordered, url) {
+			ordered = append(ordered, url)
+		}
+	}
+
+	return ordered, nil
+}
+
+// post sends body to path on the Cortex, moving on to the next candidate URL
+// when one cannot be dialed. Other errors, which may come after the body was
+// sent, and HTTP error statuses are returned to the caller as-is.
+func (d *Datasource) post(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	return d.request(ctx, http.MethodPost, path, body)
+}
+
+// get fetches path from the Cortex with the same failover as post
+func (d *Datasource) get(ctx context.Context, path string) (*http.Response, error) {
+	return d.request(ctx, http.MethodGet, path, nil)
+}
+
+// request sends a request to path on each candidate URL in turn until one
+// connects, remembering it as the last good URL. Only dial failures move on
+// to the next URL, so a request is never sent twice.
+func (d *Datasource) request(ctx context.Context, method string, path string, body []byte) (*http.Response, error) {
+	urls, err := d.candidateURLs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.config.CompressRequests && body != nil {
+		body, err = gzipBody(body)
+		if err != nil {
+			return nil, fmt.Errorf("compress request: %w", err)
+		}
+	}
+
+	var lastErr error
+	for _, baseURL := range urls {
+		resp, err := d.send(ctx, method, baseURL+path, body)
+		if err != nil {
+			var opErr *net.OpError
+			if ctx.Err() != nil || !errors.As(err, &opErr) || opErr.Op != "dial" {
+				return nil, err
+			}
+			log.DefaultLogger.Warn("Error connecting to Cortex", "url", baseURL, "error", err)
+			lastErr = err
+			continue
+		}
+
+		d.urlMu.Lock()
+		d.lastGoodURL = baseURL
+		d.urlMu.Unlock()
+		return resp, nil
+	}
+
+	return nil, lastErr
+}
+
+// send sends body to url, retrying rate limited (429) responses up to
+// Config.MaxRetries times after the delay given by their Retry-After header
+func (d *Datasource) send(ctx context.Context, method string, url string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		d.setContentHeaders(req)
+		if d.config.CompressRequests && body != nil {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		// Propagate W3C trace context; a no-op without an active span
+		propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+		resp, err := d.doRequest(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt >= d.config.MaxRetries {
+			return resp, err
+		}
+
+		wait := d.retryAfter(resp.Header.Get("Retry-After"))
+		resp.Body.Close()
+		log.DefaultLogger.Warn("Cortex rate limited request, retrying", "url", url, "wait", wait, "attempt", attempt+1)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// defaultRetryWait is used when a 429 response has no usable Retry-After
+const defaultRetryWait = time.Second
+
+// defaultMaxRetryWait caps Retry-After delays unless Config.MaxRetryWait is set
+const defaultMaxRetryWait = 30 * time.Second
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date,
+// capped at the configured maximum wait
+func (d *Datasource) retryAfter(header string) time.Duration {
+	wait := defaultRetryWait
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		wait = time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+	}
+
+	maxWait := defaultMaxRetryWait
+	if d.config.MaxRetryWait > 0 {
+		maxWait = time.Duration(d.config.MaxRetryWait) * time.Millisecond
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	return wait
+}
+
+// errStreamIdle is returned by reads from a stream that went idle too long
+var errStreamIdle = errors.New("storm stream idle timeout: no data received")
+
+// idleReader closes a response body when no data is read from it within the
+// idle window, making the blocked read fail with errStreamIdle. The window
+// restarts on every read that returns data.
+type idleReader struct {
+	body  io.ReadCloser
+	idle  time.Duration
+	timer *time.Timer
+	fired atomic.Bool
+}
+
+// newIdleReader starts the idle timer for body
+func newIdleReader(body io.ReadCloser, idle time.Duration) *idleReader {
+	r := &idleReader{body: body, idle: idle}
+	r.timer = time.AfterFunc(idle, func() {
+		r.fired.Store(true)
+		r.body.Close()
+	})
+	return r
+}
+
+// Read reads from the body, restarting the idle window on data
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if r.fired.Load() {
+		return n, errStreamIdle
+	}
+	if n > 0 {
+		r.timer.Reset(r.idle)
+	}
+	return n, err
+}
+
+// stop cancels the idle timer
+func (r *idleReader) stop() {
+	r.timer.Stop()
+}
+
+// gzipBody compresses a request body
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// contains reports whether values includes val
+func contains(values []string, val string) bool {
+	for _, v := range values {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
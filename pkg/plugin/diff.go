@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// diffBaselineTTL is how long an unrefreshed DiffMode baseline is kept
+// before it is dropped, after which the next refresh starts over
+const diffBaselineTTL = time.Hour
+
+// diffBaseline holds the idens of a query's last DiffMode result
+type diffBaseline struct {
+	idens map[string]bool
+	at    time.Time
+}
+
+// panelIdentity identifies where a query came from, using the dashboard and
+// panel headers Grafana sends with panel queries and the requesting user
+type panelIdentity struct {
+	Dashboard string
+	Panel     string
+	User      string
+}
+
+// diffKey identifies a panel query across refreshes: the query, its opts
+// less the injected time range vars, which change on every refresh, and the
+// dashboard panel and user it runs for
+func (d *Datasource) diffKey(qm QueryModel, refID string, panel panelIdentity) string {
+	opts := make(map[string]interface{}, len(qm.Opts))
+	for k, v := range qm.Opts {
+		opts[k] = v
+	}
+	if vars, ok := qm.Opts["vars"].(map[string]interface{}); ok {
+		stable := make(map[string]interface{}, len(vars))
+		for k, v := range vars {
+			stable[k] = v
+		}
+		for _, name := range timeVarNames {
+			delete(stable, d.config.TimeVarPrefix+name)
+		}
+		opts["vars"] = stable
+	}
+
+	key, _ := json.Marshal(struct {
+		RefID string
+		Query string
+		Opts  map[string]interface{}
+		Panel panelIdentity
+	}{refID, qm.StormQuery, opts, panel})
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:16])
+}
+
+// diffFrames keeps only the rows of the first frame whose iden was absent
+// from the previous result of the same query, and notes how many idens of
+// the previous result have disappeared. The current idens become the
+// baseline for the next refresh, and baselines idle past diffBaselineTTL
+// are dropped.
+func (d *Datasource) diffFrames(frames data.Frames, qm QueryModel, refID string, panel panelIdentity) data.Frames {
+	if len(frames) == 0 {
+		return frames
+	}
+	frame := frames[0]
+	idenField, _ := frame.FieldByName("iden")
+	if idenField == nil {
+		return frames
+	}
+
+	current := make(map[string]bool, idenField.Len())
+	for i := 0; i < idenField.Len(); i++ {
+		if iden, ok := idenField.At(i).(string); ok {
+			current[iden] = true
+		}
+	}
+
+	now := time.Now()
+	d.diffs.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(diffBaseline).at) > diffBaselineTTL {
+			d.diffs.Delete(key)
+		}
+		return true
+	})
+
+	key := d.diffKey(qm, refID, panel)
+	prevValue, loaded := d.diffs.Swap(key, diffBaseline{idens: current, at: now})
+	if !loaded {
+		// First run: everything is new
+		return frames
+	}
+	previous := prevValue.(diffBaseline).idens
+
+	added := frame.EmptyCopy()
+	added.Meta = frame.Meta
+	for i, field := range frame.Fields {
+		added.Fields[i].Config = field.Config
+	}
+	for row := 0; row < idenField.Len(); row++ {
+		if iden, ok := idenField.At(row).(string); ok && previous[iden] {
+			continue
+		}
+		added.AppendRow(frame.RowCopy(row)...)
+	}
+
+	removed := 0
+	for iden := range previous {
+		if !current[iden] {
+			removed++
+		}
+	}
+	added.AppendNotices(data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("%d new nodes, %d removed since the last refresh", added.Rows(), removed),
+	})
+
+	frames[0] = added
+	return frames
+}
@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// exprNode is a compiled node of a computed field expression
+type exprNode interface {
+	eval(vars func(name string) (float64, bool)) (float64, bool)
+}
+
+type exprConst float64
+
+type exprRef string
+
+type exprNeg struct {
+	operand exprNode
+}
+
+type exprBinary struct {
+	op          byte
+	left, right exprNode
+}
+
+func (c exprConst) eval(func(string) (float64, bool)) (float64, bool) {
+	return float64(c), true
+}
+
+func (r exprRef) eval(vars func(string) (float64, bool)) (float64, bool) {
+	return vars(string(r))
+}
+
+func (n exprNeg) eval(vars func(string) (float64, bool)) (float64, bool) {
+	v, ok := n.operand.eval(vars)
+	return -v, ok
+}
+
+func (b exprBinary) eval(vars func(string) (float64, bool)) (float64, bool) {
+	left, ok := b.left.eval(vars)
+	if !ok {
+		return 0, false
+	}
+	right, ok := b.right.eval(vars)
+	if !ok {
+		return 0, false
+	}
+	switch b.op {
+	case '+':
+		return left + right, true
+	case '-':
+		return left - right, true
+	case '*':
+		return left * right, true
+	case '/':
+		if right == 0 {
+			return 0, false
+		}
+		return left / right, true
+	}
+	return 0, false
+}
+
+// exprParser is a recursive descent parser for computed field expressions:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/") factor }
+//	factor = number | field | "(" expr ")" | "-" factor
+//
+// Field references are property names such as :size, .created or asn.
+type exprParser struct {
+	src string
+	pos int
+}
+
+// compileExpr parses a computed field expression
+func compileExpr(src string) (exprNode, error) {
+	p := &exprParser{src: src}
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.src[p.pos], p.pos)
+	}
+	return node, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) parseExpr() (exprNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) || (p.src[p.pos] != '+' && p.src[p.pos] != '-') {
+			return left, nil
+		}
+		op := p.src[p.pos]
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseTerm() (exprNode, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) || (p.src[p.pos] != '*' && p.src[p.pos] != '/') {
+			return left, nil
+		}
+		op := p.src[p.pos]
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseFactor() (exprNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return node, nil
+	case c == '-':
+		p.pos++
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return exprNeg{operand: operand}, nil
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		val, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.src[start:p.pos])
+		}
+		return exprConst(val), nil
+	case isExprIdentChar(c):
+		start := p.pos
+		for p.pos < len(p.src) && (isExprIdentChar(p.src[p.pos]) || p.src[p.pos] >= '0' && p.src[p.pos] <= '9') {
+			p.pos++
+		}
+		return exprRef(p.src[start:p.pos]), nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos)
+}
+
+// isExprIdentChar reports whether c can start a field reference
+func isExprIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' || c == '.'
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// flightCall is a query in flight whose result is shared by identical
+// concurrent queries
+type flightCall struct {
+	done   chan struct{}
+	frames data.Frames
+	err    error
+}
+
+// flightGroup deduplicates concurrent queries with the same key
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do runs fn, or waits for the run already in flight for key, and returns
+// its result and whether it came from another caller's run
+func (g *flightGroup) do(key string, fn func() (data.Frames, error)) (data.Frames, bool, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.frames, true, call.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.frames, call.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+
+	return call.frames, false, call.err
+}
+
+// flightKey identifies a query by everything that shapes its result except
+// the RefID, so panels running the same query share one request
+func flightKey(qm QueryModel) string {
+	key, _ := json.Marshal(struct {
+		Query    QueryModel
+		Interval time.Duration
+	}{qm, qm.interval})
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:16])
+}
+
+// runSharedQuery runs the query, sharing one Cortex request between
+// identical concurrent queries. Each caller gets its own copy of the frames
+// since they are modified after the query. A shared run cancelled by its
+// caller is retried with this caller's context.
+func (d *Datasource) runSharedQuery(ctx context.Context, qm QueryModel, refID string) (data.Frames, error) {
+	frames, shared, err := d.flights.do(flightKey(qm), func() (data.Frames, error) {
+		return d.runQuery(ctx, qm, refID)
+	})
+	if shared && errors.Is(err, context.Canceled) && ctx.Err() == nil {
+		return d.runQuery(ctx, qm, refID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return copyFrames(frames, refID), nil
+}
+
+// copyFrames copies frames with their meta, field configs and rows, setting
+// refID on each copy
+func copyFrames(frames data.Frames, refID string) data.Frames {
+	copies := make(data.Frames, len(frames))
+	for i, frame := range frames {
+		copied := frame.EmptyCopy()
+		copied.RefID = refID
+		if frame.Meta != nil {
+			meta := *frame.Meta
+			meta.Notices = append([]data.Notice(nil), frame.Meta.Notices...)
+			meta.Stats = append([]data.QueryStat(nil), frame.Meta.Stats...)
+			copied.Meta = &meta
+		}
+		for j, field := range frame.Fields {
+			if field.Config != nil {
+				config := *field.Config
+				copied.Fields[j].Config = &config
+			}
+		}
+		for row := 0; row < frame.Rows(); row++ {
+			copied.AppendRow(frame.RowCopy(row)...)
+		}
+		copies[i] = copied
+	}
+	return copies
+}
@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// modelProp describes a form property from the Synapse data model
+type modelProp struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Doc  string `json:"doc"`
+}
+
+// modelForm describes a form and its properties from the Synapse data model
+type modelForm struct {
+	Name  string               `json:"name"`
+	Type  string               `json:"type"`
+	Doc   string               `json:"doc"`
+	Props map[string]modelProp `json:"props"`
+}
+
+// synapseModel holds the model defs needed by the plugin, keyed by form name
+type synapseModel struct {
+	Forms map[string]modelForm
+}
+
+// cortexModel returns the Cortex data model, fetching it on first use and
+// caching it on the instance. The cache is refetched once if it lacks any of
+// forms, which may have been added to the Cortex since it was loaded.
+func (d *Datasource) cortexModel(ctx context.Context, forms ...string) (*synapseModel, error) {
+	d.modelMu.Lock()
+	defer d.modelMu.Unlock()
+
+	if d.model != nil {
+		stale := false
+		for _, form := range forms {
+			if _, ok := d.model.Forms[form]; !ok && !d.modelRefetched[form] {
+				d.modelRefetched[form] = true
+				stale = true
+			}
+		}
+		if !stale {
+			return d.model, nil
+		}
+	}
+
+	model, err := d.fetchModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.model = model
+	if d.modelRefetched == nil {
+		d.modelRefetched = make(map[string]bool)
+	}
+	// The model was just fetched, so forms missing from it count as refetched
+	for _, form := range forms {
+		if _, ok := model.Forms[form]; !ok {
+			d.modelRefetched[form] = true
+		}
+	}
+	return model, nil
+}
+
+// invalidateModel drops the cached model so the next use refetches it
+func (d *Datasource) invalidateModel() {
+	d.modelMu.Lock()
+	defer d.modelMu.Unlock()
+
+	d.model = nil
+	d.modelRefetched = nil
+}
+
+// fetchModel loads the model defs from the Cortex model endpoint
+func (d *Datasource) fetchModel(ctx context.Context) (*synapseModel, error) {
+	resp, err := d.get(ctx, "/api/v1/model")
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("model request failed with status: %d", resp.StatusCode)
+	}
+
+	var envelope struct {
+		Status string `json:"status"`
+		Mesg   string `json:"mesg"`
+		Result struct {
+			Types map[string]struct {
+				Info map[string]interface{} `json:"info"`
+			} `json:"types"`
+			Forms map[string]struct {
+				Type  interface{}                       `json:"type"`
+				Doc   string                            `json:"doc"`
+				Props map[string]map[string]interface{} `json:"props"`
+			} `json:"forms"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("decode model: %w", err)
+	}
+	if envelope.Status != "ok" {
+		return nil, fmt.Errorf("model request failed: %s", envelope.Mesg)
+	}
+
+	model := &synapseModel{Forms: make(map[string]modelForm, len(envelope.Result.Forms))}
+	for name, form := range envelope.Result.Forms {
+		// Form docs live on the form's type when not on the form itself
+		doc := form.Doc
+		if doc == "" {
+			doc, _ = envelope.Result.Types[name].Info["doc"].(string)
+		}
+
+		props := make(map[string]modelProp, len(form.Props))
+		for propName, prop := range form.Props {
+			propDoc, _ := prop["doc"].(string)
+			props[propName] = modelProp{
+				Name: propName,
+				Type: modelTypeName(prop["type"]),
+				Doc:  propDoc,
+			}
+		}
+
+		model.Forms[name] = modelForm{
+			Name:  name,
+			Type:  modelTypeName(form.Type),
+			Doc:   doc,
+			Props: props,
+		}
+	}
+
+	return model, nil
+}
+
+// modelTypeName returns the type name from a model type def, given either as
+// a name or as a [name, opts] pair
+func modelTypeName(typeDef interface{}) string {
+	switch v := typeDef.(type) {
+	case string:
+		return v
+	case []interface{}:
+		if len(v) > 0 {
+			if name, ok := v[0].(string); ok {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// applyModelDescriptions sets each field's description from the model doc of
+// the property it holds, and the duration unit on numeric duration-typed
+// properties. Field names are matched as relative properties of the forms in
+// the frame's form column, then as full property names such as
+// inet:ipv4:asn.
+func (d *Datasource) applyModelDescriptions(ctx context.Context, frames data.Frames) {
+	// The form column may hold display names rather than form names
+	formNames := make(map[string]string, len(d.config.FormDisplayNames))
+	for form, label := range d.config.FormDisplayNames {
+		formNames[label] = form
+	}
+
+	frameForms := make([][]string, len(frames))
+	var allForms []string
+	seen := make(map[string]bool)
+	for i, frame := range frames {
+		formField, _ := frame.FieldByName("form")
+		if formField == nil {
+			continue
+		}
+		frameSeen := make(map[string]bool)
+		for row := 0; row < formField.Len(); row++ {
+			form, ok := formField.At(row).(string)
+			if !ok {
+				continue
+			}
+			if name, ok := formNames[form]; ok {
+				form = name
+			}
+			if !frameSeen[form] {
+				frameSeen[form] = true
+				frameForms[i] = append(frameForms[i], form)
+			}
+			if !seen[form] {
+				seen[form] = true
+				allForms = append(allForms, form)
+			}
+		}
+	}
+
+	model, err := d.cortexModel(ctx, allForms...)
+	if err != nil {
+		log.DefaultLogger.Warn("Error loading model for field descriptions", "error", err)
+		return
+	}
+
+	for i, frame := range frames {
+		for _, field := range frame.Fields {
+			prop, ok := modelPropFor(model, frameForms[i], field.Name)
+			if !ok {
+				continue
+			}
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			if prop.Doc != "" {
+				field.Config.Description = prop.Doc
+			}
+			if prop.Type == "duration" && field.Type().Numeric() {
+				field.Config.Unit = d.config.DurationUnit
+			}
+		}
+	}
+}
+
+// modelPropFor returns the property named relative to one of forms, or by
+// its full name
+func modelPropFor(model *synapseModel, forms []string, name string) (modelProp, bool) {
+	for _, form := range forms {
+		if prop, ok := model.Forms[form].Props[name]; ok {
+			return prop, true
+		}
+	}
+
+	// Both form and property names contain colons, so try each colon as the
+	// split between them
+	for i := strings.LastIndex(name, ":"); i > 0; i = strings.LastIndex(name[:i], ":") {
+		if prop, ok := model.Forms[name[:i]].Props[name[i+1:]]; ok {
+			return prop, true
+		}
+	}
+	return modelProp{}, false
+}
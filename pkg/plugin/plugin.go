@@ -1,21 +1,29 @@
 package plugin
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/build"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
 // Make sure Datasource implements required interfaces. This is important to do
@@ -26,6 +34,8 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*Datasource)(nil)
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
+	_ backend.CallResourceHandler   = (*Datasource)(nil)
+	_ backend.StreamHandler         = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 )
 
@@ -36,6 +46,15 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 		return nil, fmt.Errorf("http client options: %w", err)
 	}
 
+	// Requests are bounded by their context instead of the client timeout,
+	// which would also cap longer per-query timeouts. The client timeout
+	// becomes the default Config.Timeout.
+	var clientTimeout time.Duration
+	if opts.Timeouts != nil {
+		clientTimeout = opts.Timeouts.Timeout
+		opts.Timeouts.Timeout = 0
+	}
+
 	// Create HTTP client with custom RoundTripper to add API key header
 	opts.Middlewares = []httpclient.Middleware{}
 
@@ -49,6 +68,27 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 	if err := json.Unmarshal(settings.JSONData, &config); err != nil {
 		return nil, fmt.Errorf("unmarshal config: %w", err)
 	}
+	if config.Timeout <= 0 {
+		config.Timeout = int(clientTimeout.Milliseconds())
+	}
+	if config.RequestContentType == "" {
+		config.RequestContentType = "application/json"
+	}
+	if config.StormCallPath == "" {
+		config.StormCallPath = "/api/v1/storm/call"
+	}
+	if config.StormCallMethod == "" {
+		config.StormCallMethod = http.MethodPost
+	}
+	if config.DurationUnit == "" {
+		config.DurationUnit = "ms"
+	}
+	if config.TagLabelReplacement == "" {
+		config.TagLabelReplacement = "_"
+	}
+	for k, v := range config.EnvironmentVars {
+		config.EnvironmentVars[k] = normalizeVarNumbers(v)
+	}
 
 	// Get API key from secure JSON data
 	apiKey := ""
@@ -58,21 +98,254 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 		}
 	}
 
-	return &Datasource{
+	// Wrap the client transport with OAuth2 client credentials so requests
+	// carry an automatically refreshed bearer token
+	if config.OAuth2TokenURL != "" {
+		ccConfig := clientcredentials.Config{
+			ClientID:     config.OAuth2ClientID,
+			ClientSecret: settings.DecryptedSecureJSONData["oauth2ClientSecret"],
+			TokenURL:     config.OAuth2TokenURL,
+			Scopes:       config.OAuth2Scopes,
+		}
+		timeout := cl.Timeout
+		cl = ccConfig.Client(context.WithValue(context.Background(), oauth2.HTTPClient, cl))
+		cl.Timeout = timeout
+	}
+
+	cl.CheckRedirect = checkRedirect
+
+	ds := &Datasource{
 		httpClient: &httpClientWrapper{
-			client: cl,
-			apiKey: apiKey,
+			client:    cl,
+			apiKey:    apiKey,
+			userAgent: userAgent(config),
 		},
 		settings: settings,
 		config:   config,
-	}, nil
+	}
+
+	ds.resourceHandler = ds.newResourceHandler()
+
+	if config.AhaURL != "" && config.CortexName != "" {
+		ds.aha = &ahaResolver{
+			ahaURL:     config.AhaURL,
+			cortexName: config.CortexName,
+			httpClient: ds.httpClient,
+		}
+	}
+
+	return ds, nil
+}
+
+// maxRedirects matches the net/http default redirect limit
+const maxRedirects = 10
+
+// checkRedirect follows Cortex redirects. 307 and 308 redirects are replayed
+// with the original method and body; 301, 302 and 303 redirects of a POST
+// turn into a body-less GET, which is logged since the query is lost.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	prev := via[len(via)-1]
+	if prev.Method != req.Method {
+		status := 0
+		if req.Response != nil {
+			status = req.Response.StatusCode
+		}
+		log.DefaultLogger.Warn("Cortex redirect dropped the request body; point the datasource URL at the redirect target",
+			"status", status, "from", prev.URL.String(), "to", req.URL.String(), "method", req.Method)
+	}
+	return nil
+}
+
+// userAgent returns the configured User-Agent, defaulting to the plugin name
+// and the version from build info
+func userAgent(config Config) string {
+	if config.UserAgent != "" {
+		return config.UserAgent
+	}
+	version := "dev"
+	if info, err := build.GetBuildInfo(); err == nil && info.Version != "" {
+		version = info.Version
+	}
+	return "vertex-synapse-grafana/" + version
 }
 
 // Config holds the datasource configuration
 type Config struct {
-	Version       string `json:"version"`
-	Timeout       int    `json:"timeout"`
-	TLSSkipVerify bool   `json:"tlsSkipVerify"`
+	Version string `json:"version"`
+	// Timeout bounds requests in milliseconds, defaulting to the Grafana
+	// HTTP client timeout
+	Timeout       int  `json:"timeout"`
+	TLSSkipVerify bool `json:"tlsSkipVerify"`
+
+	// Content types sent to the Cortex; AcceptContentType is only sent when set
+	RequestContentType string `json:"requestContentType"`
+	AcceptContentType  string `json:"acceptContentType"`
+
+	// RequestBodyTemplate wraps Storm requests for API gateways expecting
+	// another body shape, e.g. {"storm": {"text": ${query}, "opts": ${opts}}}.
+	// The placeholders are replaced with the JSON encoded query and opts.
+	RequestBodyTemplate string `json:"requestBodyTemplate"`
+
+	// Per-path timeouts in milliseconds, each falling back to Timeout when unset
+	QueryTimeout    int `json:"queryTimeout"`
+	HealthTimeout   int `json:"healthTimeout"`
+	ResourceTimeout int `json:"resourceTimeout"`
+
+	// AutoTimeField promotes the time-like node property to the first field
+	// so time-series panels work without configuration
+	AutoTimeField bool `json:"autoTimeField"`
+
+	// FieldDisplayNames maps field names to display names shown in panels,
+	// leaving the underlying field name untouched for transformations
+	FieldDisplayNames map[string]string `json:"fieldDisplayNames"`
+
+	// AhaURL and CortexName resolve the Cortex endpoint via AHA service
+	// discovery instead of the datasource URL
+	AhaURL     string `json:"ahaUrl"`
+	CortexName string `json:"cortexName"`
+
+	// OAuth2 client credentials; the client secret is stored in secure JSON
+	// data as oauth2ClientSecret
+	OAuth2TokenURL string   `json:"oauth2TokenUrl"`
+	OAuth2ClientID string   `json:"oauth2ClientId"`
+	OAuth2Scopes   []string `json:"oauth2Scopes"`
+
+	// NullValue is rendered in the value column for nodes with a null
+	// primary value
+	NullValue string `json:"nullValue"`
+
+	// SavedQueries holds named Storm queries selectable via
+	// QueryModel.SavedQueryName
+	SavedQueries map[string]string `json:"savedQueries"`
+
+	// DefaultView is the view iden used by queries that do not set opts.view
+	DefaultView string `json:"defaultView"`
+
+	// DefaultViewName is the name of the view used by queries that do not
+	// set opts.view, resolved to its iden on first use. DefaultView takes
+	// precedence when both are set.
+	DefaultViewName string `json:"defaultViewName"`
+
+	// AllowFeed enables the POST /feed resource for pushing data into the Cortex
+	AllowFeed bool `json:"allowFeed"`
+
+	// FallbackURLs are tried in order when the Cortex URL fails to connect
+	FallbackURLs []string `json:"fallbackUrls"`
+
+	// MaxQueryLength rejects Storm queries longer than this many bytes
+	MaxQueryLength int `json:"maxQueryLength"`
+
+	// CompressRequests gzip-compresses request bodies sent to the Cortex
+	CompressRequests bool `json:"compressRequests"`
+
+	// MaxRetries is how many times a rate limited (429) request is retried,
+	// waiting for its Retry-After delay capped at MaxRetryWait milliseconds
+	MaxRetries   int `json:"maxRetries"`
+	MaxRetryWait int `json:"maxRetryWait"`
+
+	// DefaultDecimals sets the display decimals of numeric fields
+	DefaultDecimals *int `json:"defaultDecimals"`
+
+	// BoolLabels are the true and false labels used by QueryModel.BoolAsLabel
+	BoolLabels [2]string `json:"boolLabels"`
+
+	// ValueFormatters controls value column rendering per form: raw, repr or
+	// truncate:N
+	ValueFormatters map[string]string `json:"valueFormatters"`
+
+	// FormDisplayNames maps form names to friendly labels for the form column
+	FormDisplayNames map[string]string `json:"formDisplayNames"`
+
+	// Envelope keys used to unwrap storm/call results; default to status,
+	// result and mesg
+	StatusKey  string `json:"statusKey"`
+	ResultKey  string `json:"resultKey"`
+	MessageKey string `json:"messageKey"`
+
+	// TimeUnit is the unit of numeric epoch times: ms, s, us or auto (the
+	// default). Synapse stores times as epoch milliseconds; auto also accepts
+	// seconds and microseconds by magnitude, see epochTime.
+	TimeUnit string `json:"timeUnit"`
+
+	// StreamIdleTimeout aborts a storm query when no data arrives on its
+	// message stream for this many milliseconds
+	StreamIdleTimeout int `json:"streamIdleTimeout"`
+
+	// SoftErrors returns failed queries as an empty frame carrying the error
+	// as a notice, rather than failing the panel
+	SoftErrors bool `json:"softErrors"`
+
+	// FailOnDeprecation fails queries when the Cortex warns of deprecated
+	// Storm, instead of showing the warning as a notice, so test dashboards
+	// catch it before an upgrade removes the syntax
+	FailOnDeprecation bool `json:"failOnDeprecation"`
+
+	// DedupeQueries shares one Cortex request between identical queries in
+	// flight at once, such as panels of a loading dashboard running the same
+	// Storm query
+	DedupeQueries bool `json:"dedupeQueries"`
+
+	// UserAgent overrides the default vertex-synapse-grafana/<version>
+	// User-Agent header sent with every request
+	UserAgent string `json:"userAgent"`
+
+	// GlobalMaxNodes caps the nodes collected by every storm query. A
+	// query's MaxNodes can lower but not raise it.
+	GlobalMaxNodes int `json:"globalMaxNodes"`
+
+	// ModelMetadata loads the Cortex data model to describe property fields
+	// with their model docs
+	ModelMetadata bool `json:"modelMetadata"`
+
+	// PrettyJSON indents JSON rendered into string columns
+	PrettyJSON bool `json:"prettyJSON"`
+
+	// UserScopeVar names an opts var set to the requesting Grafana user's
+	// UserScopeClaim (login, email, name or role; default login) so Storm
+	// can scope results per user. It is omitted for queries without a user.
+	UserScopeVar   string `json:"userScopeVar"`
+	UserScopeClaim string `json:"userScopeClaim"`
+
+	// MaxObjectKeys limits the columns built from storm/call object lists.
+	// The most common keys get columns; the rest are collapsed per row into
+	// an _extra JSON column.
+	MaxObjectKeys int `json:"maxObjectKeys"`
+
+	// StormCallPath and StormCallMethod locate the storm/call endpoint,
+	// defaulting to POST /api/v1/storm/call
+	StormCallPath   string `json:"stormCallPath"`
+	StormCallMethod string `json:"stormCallMethod"`
+
+	// EnvironmentVars are added to every query's opts.vars unless the query
+	// or the injected time range already sets them, so dashboards can use
+	// per-datasource values such as a feed name
+	EnvironmentVars map[string]interface{} `json:"environmentVars"`
+
+	// HealthCacheTTL reuses a healthy CheckHealth result for this many
+	// milliseconds. Failures are never cached so fixes show immediately.
+	HealthCacheTTL int `json:"healthCacheTTL"`
+
+	// DurationUnit is the Grafana unit of duration properties, detected by a
+	// duration name or model type. Synapse durations are milliseconds, the
+	// default.
+	DurationUnit string `json:"durationUnit"`
+
+	// TagLabelReplacement replaces each character of a tag name not valid in
+	// a label name when QueryModel.TagsAsLabels is set, defaulting to "_" so
+	// cno.infra.anon becomes cno_infra_anon
+	TagLabelReplacement string `json:"tagLabelReplacement"`
+
+	// TimeVarPrefix namespaces the injected time range vars so they cannot
+	// overwrite user vars of the same name, e.g. __grafana_ for
+	// $__grafana_timeFrom. Empty keeps the unprefixed names.
+	TimeVarPrefix string `json:"timeVarPrefix"`
+
+	// MaxTagsPerNode truncates the tags column to the first N sorted tags
+	// with a "+K more" suffix. The raw node column keeps the full set.
+	MaxTagsPerNode int `json:"maxTagsPerNode"`
 }
 
 // Datasource is an example datasource which can respond to data queries, reports
@@ -81,13 +354,36 @@ type Datasource struct {
 	settings   backend.DataSourceInstanceSettings
 	httpClient *httpClientWrapper
 	config     Config
-	queryModel *QueryModel // Store current query model for parsing functions
+	aha        *ahaResolver
+
+	urlMu       sync.Mutex
+	lastGoodURL string // Cortex URL that last accepted a connection
+
+	streams sync.Map // stream path -> QueryModel for incremental queries
+
+	diffs sync.Map // diffKey -> diffBaseline of the last DiffMode result
+
+	healthMu     sync.Mutex
+	healthResult *backend.CheckHealthResult // last healthy result
+	healthAt     time.Time
+
+	modelMu        sync.Mutex
+	model          *synapseModel   // cached Cortex model defs, loaded on first use
+	modelRefetched map[string]bool // missing forms the model was refetched for
+
+	viewMu   sync.Mutex
+	viewIden string // DefaultViewName resolved to its iden
+
+	flights flightGroup // identical queries in flight, when DedupeQueries is set
+
+	resourceHandler backend.CallResourceHandler
 }
 
 // httpClientWrapper wraps the HTTP client to add the API key header
 type httpClientWrapper struct {
-	client *http.Client
-	apiKey string
+	client    *http.Client
+	apiKey    string
+	userAgent string
 }
 
 // Do executes the HTTP request with the API key header
@@ -95,6 +391,9 @@ func (c *httpClientWrapper) Do(req *http.Request) (*http.Response, error) {
 	if c.apiKey != "" {
 		req.Header.Set("X-API-KEY", c.apiKey)
 	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
 	return c.client.Do(req)
 }
 
@@ -105,6 +404,11 @@ func (d *Datasource) Dispose() {
 	// Clean up datasource instance resources.
 }
 
+// CallResource handles resource requests sent from Grafana to the plugin.
+func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return d.resourceHandler.CallResource(ctx, req, sender)
+}
+
 // QueryData handles multiple queries and returns multiple responses.
 // req contains the queries []DataQuery (where each query contains RefID as a unique identifier).
 // The QueryDataResponse contains a map of RefID to the response for each query, and each response
@@ -113,9 +417,20 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
+	panel := panelIdentity{
+		Dashboard: req.GetHTTPHeader("X-Dashboard-Uid"),
+		Panel:     req.GetHTTPHeader("X-Panel-Id"),
+	}
+	if req.PluginContext.User != nil {
+		panel.User = req.PluginContext.User.Login
+	}
+
 	// loop over queries and execute them individually.
 	for _, q := range req.Queries {
-		res := d.query(ctx, req.PluginContext, q)
+		res := d.query(ctx, req.PluginContext, q, panel)
+		if res.Error != nil && d.config.SoftErrors {
+			res = softErrorResponse(res.Error, q.RefID)
+		}
 
 		// save the response in a hashmap
 		// based on with RefID as identifier
@@ -125,7 +440,23 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	return response, nil
 }
 
-func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+// stormCommentPattern matches Storm // line comments, /* */ block comments
+// and whitespace
+var stormCommentPattern = regexp.MustCompile(`(?s)//[^\n]*|/\*.*?\*/|\s+`)
+
+// softErrorResponse replaces a failed response with an empty frame whose
+// error notice carries err
+func softErrorResponse(err error, refID string) backend.DataResponse {
+	frame := data.NewFrame("error")
+	frame.RefID = refID
+	frame.AppendNotices(data.Notice{
+		Severity: data.NoticeSeverityError,
+		Text:     err.Error(),
+	})
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, panel panelIdentity) backend.DataResponse {
 	var response backend.DataResponse
 
 	// Parse the query
@@ -136,36 +467,477 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 		return response
 	}
 
+	if qm.SavedQueryName != "" {
+		saved, ok := d.config.SavedQueries[qm.SavedQueryName]
+		if !ok {
+			response.Error = fmt.Errorf("saved query %q not found", qm.SavedQueryName)
+			return response
+		}
+		qm.StormQuery = saved
+	}
+
 	if qm.StormQuery == "" {
 		response.Error = fmt.Errorf("storm query is required")
 		return response
 	}
 
+	if stormCommentPattern.ReplaceAllString(qm.StormQuery, "") == "" {
+		frame := data.NewFrame("storm")
+		frame.RefID = query.RefID
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "storm query contains only whitespace and comments, so it was not run",
+		})
+		response.Frames = data.Frames{frame}
+		return response
+	}
+
+	if d.config.MaxQueryLength > 0 && len(qm.StormQuery) > d.config.MaxQueryLength {
+		response.Error = fmt.Errorf("storm query is %d bytes, exceeding the maximum of %d; consider a saved query or moving logic into a storm/call package function",
+			len(qm.StormQuery), d.config.MaxQueryLength)
+		return response
+	}
+
+	if len(qm.PostFilters) > 0 {
+		qm.postFilters, err = compilePostFilters(qm.PostFilters)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	// Restore integer typing of user-supplied vars lost in JSON decoding
+	if vars, ok := qm.Opts["vars"].(map[string]interface{}); ok {
+		for k, v := range vars {
+			vars[k] = normalizeVarNumbers(v)
+		}
+		if err := validateByteVars(vars, qm.VarTypes); err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
 	// Add Grafana time range to opts
 	qm = d.injectTimeRange(qm, query.TimeRange)
+	qm.timeRange = query.TimeRange
+	qm.interval = query.Interval
+
+	if len(d.config.EnvironmentVars) > 0 {
+		vars := qm.Opts["vars"].(map[string]interface{})
+		for k, v := range d.config.EnvironmentVars {
+			if _, ok := vars[k]; !ok {
+				vars[k] = v
+			}
+		}
+	}
+
+	if d.config.UserScopeVar != "" {
+		qm = d.injectUserScope(qm, pCtx.User)
+	}
+
+	if _, ok := qm.Opts["view"]; !ok {
+		view, err := d.defaultView(ctx)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		if view != "" {
+			qm.Opts["view"] = view
+			qm.viewByName = d.config.DefaultView == ""
+		}
+	}
+
+	if qm.UseCall && len(qm.CallArgs) > 0 {
+		qm = d.injectCallArgs(qm)
+	}
+
+	if qm.WithEdges && !qm.UseCall {
+		if _, ok := qm.Opts["graph"]; !ok {
+			qm.Opts["graph"] = true
+		}
+	}
+
+	if limit := d.config.GlobalMaxNodes; limit > 0 && (qm.MaxNodes <= 0 || qm.MaxNodes > limit) {
+		qm.MaxNodes = limit
+		qm.globalCap = true
+	}
+
+	if qm.UseMirror {
+		qm, err = d.injectMirror(qm)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	timeoutMs := d.config.QueryTimeout
+	if qm.TimeoutMs > 0 {
+		timeoutMs = qm.TimeoutMs
+	}
+	ctx, cancel := d.withTimeout(ctx, timeoutMs)
+	defer cancel()
+
+	if qm.DryRun {
+		response.Frames, response.Error = d.dryRunFrames(ctx, qm, query.RefID)
+		return response
+	}
+
+	if qm.Explain {
+		response.Frames, response.Error = d.explainQuery(ctx, qm, query.RefID)
+		return response
+	}
+
+	if qm.Incremental && !qm.UseCall {
+		response.Frames = d.incrementalFrames(pCtx, qm, query.RefID)
+		return response
+	}
 
 	// Execute Storm query
-	var frames data.Frames
-	if qm.UseCall {
-		frames, err = d.queryStormCall(ctx, qm, query.RefID)
-	} else {
-		frames, err = d.queryStorm(ctx, qm, query.RefID)
+	run := d.runQuery
+	if d.config.DedupeQueries {
+		run = d.runSharedQuery
+	}
+	frames, err := run(ctx, qm, query.RefID)
+	if err != nil && qm.viewByName && isUnknownViewError(err) {
+		// The named view may have been recreated under a new iden
+		d.invalidateDefaultView()
+		if view, viewErr := d.defaultView(ctx); viewErr == nil && view != qm.Opts["view"] {
+			qm.Opts["view"] = view
+			frames, err = run(ctx, qm, query.RefID)
+		}
 	}
 
 	if err != nil {
 		response.Error = err
+		var denied *authDenyError
+		if errors.As(err, &denied) {
+			response.Status = backend.StatusForbidden
+			response.ErrorSource = backend.ErrorSourceDownstream
+		}
 		return response
 	}
+	if qm.DiffMode {
+		frames = d.diffFrames(frames, qm, query.RefID, panel)
+	}
+	d.decorateFrames(ctx, frames, qm)
 	response.Frames = frames
 
 	return response
 }
 
+// decorateFrames applies the display settings shared by every query result:
+// executed query, display names, units, descriptions, decimals and bool
+// labels
+func (d *Datasource) decorateFrames(ctx context.Context, frames data.Frames, qm QueryModel) {
+	d.applyExecutedQuery(frames, qm)
+	d.applyFieldDisplayNames(frames)
+	d.applyDurationUnits(frames)
+	if d.config.ModelMetadata {
+		d.applyModelDescriptions(ctx, frames)
+	}
+	d.applyDecimals(frames, qm)
+	if qm.BoolAsLabel {
+		d.applyBoolLabels(frames)
+	}
+}
+
+// runQuery executes the Storm query via storm/call or the message stream
+func (d *Datasource) runQuery(ctx context.Context, qm QueryModel, refID string) (data.Frames, error) {
+	if qm.UseCall {
+		return d.queryStormCall(ctx, qm, refID)
+	}
+	return d.queryStorm(ctx, qm, refID)
+}
+
+// applyFieldDisplayNames sets the configured display names on matching fields
+func (d *Datasource) applyFieldDisplayNames(frames data.Frames) {
+	if len(d.config.FieldDisplayNames) == 0 {
+		return
+	}
+
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if displayName, ok := d.config.FieldDisplayNames[field.Name]; ok {
+				if field.Config == nil {
+					field.Config = &data.FieldConfig{}
+				}
+				field.Config.DisplayName = displayName
+			}
+		}
+	}
+}
+
+// applyDecimals sets display decimals on numeric fields from the query's
+// per-field overrides or the datasource default
+func (d *Datasource) applyDecimals(frames data.Frames, qm QueryModel) {
+	if d.config.DefaultDecimals == nil && len(qm.Decimals) == 0 {
+		return
+	}
+
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if !field.Type().Numeric() {
+				continue
+			}
+
+			decimals, ok := qm.Decimals[field.Name]
+			if !ok {
+				if d.config.DefaultDecimals == nil {
+					continue
+				}
+				decimals = *d.config.DefaultDecimals
+			}
+			if decimals < 0 {
+				continue
+			}
+
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			field.Config.SetDecimals(uint16(decimals))
+		}
+	}
+}
+
+// applyBoolLabels replaces boolean fields with string fields holding the
+// configured true/false labels. Null values stay null.
+func (d *Datasource) applyBoolLabels(frames data.Frames) {
+	trueLabel, falseLabel := d.config.BoolLabels[0], d.config.BoolLabels[1]
+	if trueLabel == "" {
+		trueLabel = "true"
+	}
+	if falseLabel == "" {
+		falseLabel = "false"
+	}
+
+	for _, frame := range frames {
+		for i, field := range frame.Fields {
+			if field.Type() != data.FieldTypeBool && field.Type() != data.FieldTypeNullableBool {
+				continue
+			}
+
+			labels := make([]*string, field.Len())
+			for j := 0; j < field.Len(); j++ {
+				val, ok := field.ConcreteAt(j)
+				if !ok {
+					continue
+				}
+				label := falseLabel
+				if val.(bool) {
+					label = trueLabel
+				}
+				labels[j] = &label
+			}
+
+			labelField := data.NewField(field.Name, field.Labels, labels)
+			labelField.Config = field.Config
+			frame.Fields[i] = labelField
+		}
+	}
+}
+
+// TopNQuery tallies the Count most frequent values of Prop. Prop may name a
+// node property or one of form, value or tags.
+type TopNQuery struct {
+	Prop  string `json:"prop"`
+	Count int    `json:"count"`
+}
+
 // QueryModel represents the query structure
 type QueryModel struct {
 	StormQuery string                 `json:"stormQuery"`
 	UseCall    bool                   `json:"useCall"`
 	Opts       map[string]interface{} `json:"opts"`
+
+	// DistinguishNull emits absent values in string columns as null
+	// instead of an empty string
+	DistinguishNull bool `json:"distinguishNull"`
+
+	// IncludeRawNode adds a _raw column holding the original node message
+	IncludeRawNode bool `json:"includeRawNode"`
+
+	// ColorTagPrefix adds a _tag_category column with the first node tag
+	// under this prefix, for use in value mappings
+	ColorTagPrefix string `json:"colorTagPrefix"`
+
+	// UseMirror directs read-only queries to a Cortex mirror
+	UseMirror bool `json:"useMirror"`
+
+	// SingleJSONColumn returns a storm/call result as one row with a single
+	// json column, bypassing type detection
+	SingleJSONColumn bool `json:"singleJSONColumn"`
+
+	// CallArgs are positional arguments for storm/call, exposed to the query
+	// as $args. Entries may contain ${var} tokens resolved from ScopedVars.
+	CallArgs []interface{} `json:"callArgs"`
+	// ScopedVars holds dashboard variable values resolved by the frontend
+	ScopedVars map[string]string `json:"scopedVars"`
+
+	// MessageStats adds a frame counting each Storm message type received
+	MessageStats bool `json:"messageStats"`
+
+	// MaxNodes caps the number of nodes collected from a storm query
+	MaxNodes int `json:"maxNodes"`
+
+	// PropsAsJSON emits node props as a single JSON props column instead of
+	// one column per property
+	PropsAsJSON bool `json:"propsAsJSON"`
+
+	// SavedQueryName runs the named datasource saved query instead of
+	// StormQuery
+	SavedQueryName string `json:"savedQueryName"`
+
+	// FieldTypes declares column types (string, int, float, bool or time),
+	// overriding type detection for the named columns
+	FieldTypes map[string]string `json:"fieldTypes"`
+
+	// Decimals overrides Config.DefaultDecimals for the named numeric fields
+	Decimals map[string]int `json:"decimals"`
+
+	// CreationRate returns a time series counting nodes per query interval
+	// bucket of their .created time
+	CreationRate bool `json:"creationRate"`
+
+	// TagRatePrefix returns a time series counting nodes per query interval
+	// bucket of their .created time, with one series per tag directly under
+	// this prefix, for stacked charts of activity by tag
+	TagRatePrefix string `json:"tagRatePrefix"`
+
+	// IncludeLayer adds a layer column with the node's layer iden when the
+	// node message provides it
+	IncludeLayer bool `json:"includeLayer"`
+
+	// IncludeProvenance adds a provenance column with the node's provenance
+	// when the node message provides it, null otherwise. The Cortex has no
+	// API to look provenance up separately.
+	IncludeProvenance bool `json:"includeProvenance"`
+
+	// TableColumns fixes the storm output columns and their order. Columns
+	// absent from a node are null.
+	TableColumns []string `json:"tableColumns"`
+
+	// WithEdges runs the query in graph mode and adds an edges frame linking
+	// node idens, for node graph and relationship panels
+	WithEdges bool `json:"withEdges"`
+
+	// LogsMode shapes nodes into the logs data frame format for Explore
+	LogsMode bool `json:"logsMode"`
+
+	// ComputedFields maps new column names to arithmetic expressions over
+	// numeric node properties, e.g. "size_mb": ":size / 1048576"
+	ComputedFields map[string]string `json:"computedFields"`
+
+	// Incremental streams storm results over Grafana Live in batches of
+	// BatchSize nodes instead of returning them all at once
+	Incremental bool `json:"incremental"`
+	BatchSize   int  `json:"batchSize"`
+
+	// CountOnly returns just the number of matching nodes, using the Storm
+	// count command so node data is not transferred
+	CountOnly bool `json:"countOnly"`
+
+	// FormLabels keeps raw form names and adds Config.FormDisplayNames
+	// labels as a form_label column
+	FormLabels bool `json:"formLabels"`
+
+	// PartitionBy emits one frame per distinct value of this property, with
+	// nodes lacking it in an "unknown" frame
+	PartitionBy string `json:"partitionBy"`
+
+	// IncludeNdef adds an ndef column (form=value) as a stable join key
+	IncludeNdef bool `json:"includeNdef"`
+
+	// TopN returns the most frequent values of a property as a value/count
+	// summary instead of the node table
+	TopN *TopNQuery `json:"topN"`
+
+	// HeatmapMode reshapes a storm/call {xbucket: {ybucket: count}} result
+	// into heatmap rows: an x field then one count field per y bucket
+	HeatmapMode bool `json:"heatmapMode"`
+
+	// DiffMode emits only nodes whose iden was not in this query's previous
+	// result, with a notice counting the nodes that disappeared
+	DiffMode bool `json:"diffMode"`
+
+	// EditSummary returns a frame counting the edits reported by node:edits
+	// messages instead of the node table, for queries that modify data
+	EditSummary bool `json:"editSummary"`
+
+	// TagTree returns the hierarchy of syn:tag nodes as tag, parent and
+	// depth columns for tree and treemap panels
+	TagTree bool `json:"tagTree"`
+
+	// MultiFrameKey splits a storm/call result list of {"name": ..., key:
+	// ...} envelopes into one frame per envelope, named by name and built
+	// from the value under this key
+	MultiFrameKey string `json:"multiFrameKey"`
+
+	// VarTypes declares opts.vars types. Vars of type bytes, and vars named
+	// with a _b64 suffix, must hold base64 that Storm decodes with
+	// $lib.base64.decode, since the JSON API cannot carry raw bytes.
+	VarTypes map[string]string `json:"varTypes"`
+
+	// Explain validates the query with the Cortex instead of running it.
+	// Synapse does not expose execution plans or cost estimates, so the
+	// result reports only whether the query parses.
+	Explain bool `json:"explain"`
+
+	// MinPropCoverage (0-1) only gives columns to properties present on at
+	// least this fraction of nodes. Rarer properties are collected per node
+	// into a _sparse JSON column.
+	MinPropCoverage float64 `json:"minPropCoverage"`
+
+	// TimeoutMs overrides Config.QueryTimeout and Config.Timeout for this
+	// query, and may exceed the Grafana HTTP client timeout
+	TimeoutMs int `json:"timeoutMs"`
+
+	// MaxRows caps the items of a storm/call result list turned into rows
+	MaxRows int `json:"maxRows"`
+
+	// DryRun returns the request that would be sent, with secret-looking
+	// vars redacted, instead of calling the Cortex
+	DryRun bool `json:"dryRun"`
+
+	// EnsureIden always emits the iden column, even when TableColumns omits
+	// it, and marks it as the row key for joins and data links
+	EnsureIden bool `json:"ensureIden"`
+
+	// TagsAsLabels emits one frame per distinct tag set, with each tag as a
+	// field label set to "true", so time series split by tag. It is ignored
+	// with PartitionBy.
+	TagsAsLabels bool `json:"tagsAsLabels"`
+
+	// ValueAsNumber emits the value column as a number when every node's
+	// primary value is numeric, such as inet:ipv4 or int forms. Mixed
+	// results keep the string column.
+	ValueAsNumber bool `json:"valueAsNumber"`
+
+	// PostFilters drop nodes and storm/call rows client-side, before frames
+	// are built, for quick filtering without rewriting the Storm query
+	PostFilters []PostFilter `json:"postFilters"`
+
+	// BoolAsLabel renders boolean fields as strings using Config.BoolLabels
+	BoolAsLabel bool `json:"boolAsLabel"`
+
+	// Request time range and interval, set from the Grafana query
+	timeRange backend.TimeRange
+	interval  time.Duration
+
+	// globalCap is set when MaxNodes comes from Config.GlobalMaxNodes
+	globalCap bool
+
+	// viewByName is set when opts.view comes from Config.DefaultViewName
+	viewByName bool
+
+	// postFilters are the compiled PostFilters
+	postFilters []postFilter
+}
+
+// timeVarNames lists the vars set by injectTimeRange, before
+// Config.TimeVarPrefix
+var timeVarNames = []string{
+	"timeFrom", "timeTo", "timeRange", "dateFrom", "dateTo",
+	"timeFromMs", "timeToMs", "timeFromSec", "timeToSec",
 }
 
 func (d *Datasource) injectTimeRange(qm QueryModel, timeRange backend.TimeRange) QueryModel {
@@ -180,30 +952,33 @@ func (d *Datasource) injectTimeRange(qm QueryModel, timeRange backend.TimeRange)
 		vars = make(map[string]interface{})
 	}
 
+	// Injected vars are namespaced by Config.TimeVarPrefix
+	prefix := d.config.TimeVarPrefix
+
 	// Primary Storm time range variables
 	// Storm expects ISO format strings for absolute time: YYYY-MM-DDTHH:MM:SS.sssZ
-	vars["timeFrom"] = timeRange.From.Format("2006-01-02T15:04:05.000Z")
-	vars["timeTo"] = timeRange.To.Format("2006-01-02T15:04:05.000Z")
+	vars[prefix+"timeFrom"] = timeRange.From.Format("2006-01-02T15:04:05.000Z")
+	vars[prefix+"timeTo"] = timeRange.To.Format("2006-01-02T15:04:05.000Z")
 
 	// Storm tuple format for @= range queries like .created@=($timeRange)
 	// This is the primary format for Storm time range filtering
-	vars["timeRange"] = []interface{}{
+	vars[prefix+"timeRange"] = []interface{}{
 		timeRange.From.Format("2006-01-02T15:04:05.000Z"),
 		timeRange.To.Format("2006-01-02T15:04:05.000Z"),
 	}
 
 	// Alternative formats for flexibility
 	// Date only format (YYYY-MM-DD)
-	vars["dateFrom"] = timeRange.From.Format("2006-01-02")
-	vars["dateTo"] = timeRange.To.Format("2006-01-02")
+	vars[prefix+"dateFrom"] = timeRange.From.Format("2006-01-02")
+	vars[prefix+"dateTo"] = timeRange.To.Format("2006-01-02")
 
 	// Unix timestamps (milliseconds) - for custom Storm functions
-	vars["timeFromMs"] = timeRange.From.UnixMilli()
-	vars["timeToMs"] = timeRange.To.UnixMilli()
+	vars[prefix+"timeFromMs"] = timeRange.From.UnixMilli()
+	vars[prefix+"timeToMs"] = timeRange.To.UnixMilli()
 
 	// Unix timestamps (seconds)
-	vars["timeFromSec"] = timeRange.From.Unix()
-	vars["timeToSec"] = timeRange.To.Unix()
+	vars[prefix+"timeFromSec"] = timeRange.From.Unix()
+	vars[prefix+"timeToSec"] = timeRange.To.Unix()
 
 	// Update opts with the vars
 	qm.Opts["vars"] = vars
@@ -211,224 +986,1922 @@ func (d *Datasource) injectTimeRange(qm QueryModel, timeRange backend.TimeRange)
 	return qm
 }
 
-// StormMessage represents a message from the Storm API
-type StormMessage []interface{}
-
-// StormNode represents a node structure from Storm
-type StormNode struct {
-	Form  string
-	Value interface{}
-	Iden  string
-	Tags  map[string]interface{}
-	Props map[string]interface{}
-}
-
-func (d *Datasource) queryStorm(ctx context.Context, qm QueryModel, refID string) (data.Frames, error) {
-	// Build request URL for Storm query
-	url := fmt.Sprintf("%s/api/v1/storm", d.settings.URL)
-
-	// Create request body with query and opts
-	reqBody, err := json.Marshal(map[string]interface{}{
-		"query": qm.StormQuery,
-		"opts":  qm.Opts,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
-	}
+// varTokenPattern matches ${var} tokens in call args
+var varTokenPattern = regexp.MustCompile(`\$\{(\w+)\}`)
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
+// injectUserScope sets Config.UserScopeVar from the user's identity claim,
+// overriding any dashboard-supplied value so users cannot widen their scope.
+// Background and alerting queries have no user and get no var.
+func (d *Datasource) injectUserScope(qm QueryModel, user *backend.User) QueryModel {
+	vars, ok := qm.Opts["vars"].(map[string]interface{})
+	if !ok {
+		vars = make(map[string]interface{})
+		qm.Opts["vars"] = vars
 	}
-	req.Header.Set("Content-Type", "application/json")
+	delete(vars, d.config.UserScopeVar)
 
-	// Execute request
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("execute request: %w", err)
+	if user == nil {
+		return qm
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("storm query failed with status: %d", resp.StatusCode)
+	var claim string
+	switch d.config.UserScopeClaim {
+	case "email":
+		claim = user.Email
+	case "name":
+		claim = user.Name
+	case "role":
+		claim = user.Role
+	default:
+		claim = user.Login
 	}
-
-	// Parse streaming response - collect all nodes first
-	type NodeRecord struct {
-		Form  string
-		Value string
-		Iden  string
-		Tags  string
-		Props map[string]interface{}
+	if claim != "" {
+		vars[d.config.UserScopeVar] = claim
 	}
-	var nodes []NodeRecord
-	allPropKeys := make(map[string]bool)
+	return qm
+}
 
-	decoder := json.NewDecoder(resp.Body)
-	for {
-		var msg StormMessage
-		if err := decoder.Decode(&msg); err != nil {
-			if err.Error() == "EOF" || err.Error() == "unexpected end of JSON input" {
-				break
-			}
-			// Try to continue on partial errors
-			log.DefaultLogger.Warn("Error decoding storm message", "error", err)
+// injectCallArgs interpolates scoped variables into CallArgs and adds them to
+// opts.vars as args. An arg consisting solely of a ${var} token takes the
+// variable's JSON type, so numeric variables arrive as numbers.
+func (d *Datasource) injectCallArgs(qm QueryModel) QueryModel {
+	args := make([]interface{}, len(qm.CallArgs))
+	for i, arg := range qm.CallArgs {
+		str, ok := arg.(string)
+		if !ok {
+			args[i] = arg
 			continue
 		}
 
-		if len(msg) < 2 {
-			continue
+		if match := varTokenPattern.FindStringSubmatch(str); match != nil && match[0] == str {
+			if val, exists := qm.ScopedVars[match[1]]; exists {
+				args[i] = typedVarValue(val)
+				continue
+			}
 		}
 
-		msgType, ok := msg[0].(string)
+		args[i] = varTokenPattern.ReplaceAllStringFunc(str, func(token string) string {
+			name := varTokenPattern.FindStringSubmatch(token)[1]
+			if val, exists := qm.ScopedVars[name]; exists {
+				return val
+			}
+			return token
+		})
+	}
+
+	vars, ok := qm.Opts["vars"].(map[string]interface{})
+	if !ok || vars == nil {
+		vars = make(map[string]interface{})
+	}
+	vars["args"] = args
+	qm.Opts["vars"] = vars
+
+	return qm
+}
+
+// typedVarValue converts a variable value to a number or bool when it parses
+// as one, otherwise it is kept as a string. Values with leading zeros such as
+// "007" are identifiers rather than numbers, and NaN and Inf have no JSON
+// encoding, so both stay strings.
+func typedVarValue(val string) interface{} {
+	if hasLeadingZero(val) {
+		return val
+	}
+	if intVal, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return intVal
+	}
+	if floatVal, err := strconv.ParseFloat(val, 64); err == nil && !math.IsInf(floatVal, 0) && !math.IsNaN(floatVal) {
+		return floatVal
+	}
+	if val == "true" || val == "false" {
+		return val == "true"
+	}
+	return val
+}
+
+// hasLeadingZero reports whether val, after an optional sign, is a zero
+// followed by another digit
+func hasLeadingZero(val string) bool {
+	val = strings.TrimLeft(val, "+-")
+	return len(val) > 1 && val[0] == '0' && val[1] >= '0' && val[1] <= '9'
+}
+
+// injectMirror sets opts.mirror for read-only queries. Editing queries
+// (opts.readonly explicitly false) must run on the leader.
+func (d *Datasource) injectMirror(qm QueryModel) (QueryModel, error) {
+	if readonly, ok := qm.Opts["readonly"].(bool); ok && !readonly {
+		return qm, fmt.Errorf("use mirror cannot be combined with edit mode (readonly is false)")
+	}
+
+	qm.Opts["readonly"] = true
+	qm.Opts["mirror"] = true
+
+	return qm, nil
+}
+
+// validateByteVars checks that bytes vars hold valid base64
+func validateByteVars(vars map[string]interface{}, varTypes map[string]string) error {
+	for name, val := range vars {
+		if varTypes[name] != "bytes" && !strings.HasSuffix(name, "_b64") {
+			continue
+		}
+		str, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("var %q must be a base64 string, got %T", name, val)
+		}
+		if _, err := base64.StdEncoding.DecodeString(str); err != nil {
+			return fmt.Errorf("var %q is not valid base64: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// normalizeVarNumbers converts whole-number float64 values, including those
+// nested in lists and maps, back to int64 so integer vars do not reach Storm
+// as floats like 5.0
+func normalizeVarNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeVarNumbers(item)
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalizeVarNumbers(item)
+		}
+	}
+	return val
+}
+
+// StormMessage represents a message from the Storm API
+type StormMessage []interface{}
+
+// StormNode represents a node structure from Storm
+type StormNode struct {
+	Form  string
+	Value interface{}
+	Iden  string
+	Tags  map[string]interface{}
+	Props map[string]interface{}
+}
+
+func (d *Datasource) queryStorm(ctx context.Context, qm QueryModel, refID string) (data.Frames, error) {
+	if qm.CountOnly {
+		qm.StormQuery += "\n| count"
+	}
+
+	// Create request body with query and opts
+	reqBody, err := d.requestBody(qm.StormQuery, qm.Opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute request
+	start := time.Now()
+	resp, err := d.post(ctx, "/api/v1/storm", reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storm query failed with status: %d", resp.StatusCode)
+	}
+
+	var body io.Reader = resp.Body
+	if d.config.StreamIdleTimeout > 0 {
+		idle := newIdleReader(resp.Body, time.Duration(d.config.StreamIdleTimeout)*time.Millisecond)
+		defer idle.stop()
+		body = idle
+	}
+
+	var frames data.Frames
+	if qm.CountOnly {
+		frames, err = parseCountStream(body, qm.StormQuery, refID)
+	} else {
+		frames, err = d.parseStormStream(body, qm, refID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Client-side time including network and decoding, for comparison
+	// with the Cortex's server_elapsed_ms
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+	appendQueryStat(frames[0], "elapsed_ms", "ms", elapsed)
+	return frames, nil
+}
+
+// optsMarshalError explains a failure to marshal opts by marshalling each
+// opt, and each var, separately to find the value at fault
+func optsMarshalError(opts map[string]interface{}, err error) error {
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if vars, ok := opts[k].(map[string]interface{}); ok && k == "vars" {
+			names := make([]string, 0, len(vars))
+			for name := range vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if _, varErr := json.Marshal(vars[name]); varErr != nil {
+					return fmt.Errorf("marshal request: var %s holds a %T that cannot be marshaled: %w", name, vars[name], varErr)
+				}
+			}
+		}
+		if _, optErr := json.Marshal(opts[k]); optErr != nil {
+			return fmt.Errorf("marshal request: opt %s holds a %T that cannot be marshaled: %w", k, opts[k], optErr)
+		}
+	}
+	return fmt.Errorf("marshal request: %w", err)
+}
+
+// secretVarPattern matches var names whose values are redacted when the
+// query is shown
+var secretVarPattern = regexp.MustCompile(`(?i)key|token|secret|passw|cred`)
+
+// dryRunFrames returns a one-row frame with the URL, query and opts that
+// would be sent for qm
+func (d *Datasource) dryRunFrames(ctx context.Context, qm QueryModel, refID string) (data.Frames, error) {
+	urls, err := d.candidateURLs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	method, path := http.MethodPost, "/api/v1/storm"
+	if qm.UseCall {
+		method, path = d.config.StormCallMethod, d.config.StormCallPath
+	}
+
+	optsJSON, err := json.Marshal(d.redactedOpts(qm.Opts))
+	if err != nil {
+		return nil, fmt.Errorf("marshal opts: %w", err)
+	}
+
+	frame := data.NewFrame("dryrun",
+		data.NewField("method", nil, []string{method}),
+		data.NewField("url", nil, []string{urls[0] + path}),
+		data.NewField("query", nil, []string{d.redactSecrets(sentQuery(qm))}),
+		data.NewField("opts", nil, []string{string(optsJSON)}),
+	)
+	frame.RefID = refID
+	return data.Frames{frame}, nil
+}
+
+// sentQuery returns the Storm query as sent to the Cortex for qm
+func sentQuery(qm QueryModel) string {
+	if qm.CountOnly && !qm.UseCall {
+		return qm.StormQuery + "\n| count"
+	}
+	return qm.StormQuery
+}
+
+// redactedOpts copies opts with the values of secret-named vars and of vars
+// holding secure settings redacted
+func (d *Datasource) redactedOpts(opts map[string]interface{}) map[string]interface{} {
+	// Copy opts so redaction leaves the query untouched
+	redacted := make(map[string]interface{}, len(opts))
+	for k, v := range opts {
+		redacted[k] = v
+	}
+	if vars, ok := opts["vars"].(map[string]interface{}); ok {
+		redactedVars := make(map[string]interface{}, len(vars))
+		for k, v := range vars {
+			redactedVars[k] = v
+			if str, isStr := v.(string); secretVarPattern.MatchString(k) || (isStr && d.redactSecrets(str) != str) {
+				redactedVars[k] = "[redacted]"
+			}
+		}
+		redacted["vars"] = redactedVars
+	}
+	return redacted
+}
+
+// redactSecrets replaces any secure setting value found in s
+func (d *Datasource) redactSecrets(s string) string {
+	for _, secret := range d.settings.DecryptedSecureJSONData {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "[redacted]")
+		}
+	}
+	return s
+}
+
+// applyExecutedQuery records the query and opts sent for qm on each frame,
+// shown in the Grafana query inspector
+func (d *Datasource) applyExecutedQuery(frames data.Frames, qm QueryModel) {
+	executed := d.redactSecrets(sentQuery(qm))
+	if optsJSON, err := json.Marshal(d.redactedOpts(qm.Opts)); err == nil {
+		executed += "\n// opts: " + d.redactSecrets(string(optsJSON))
+	}
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.ExecutedQueryString = executed
+	}
+}
+
+// explainQuery checks the query syntax with the Cortex reqvalidstorm
+// endpoint, returning a one-row frame with the outcome
+func (d *Datasource) explainQuery(ctx context.Context, qm QueryModel, refID string) (data.Frames, error) {
+	reqBody, err := d.requestBody(qm.StormQuery, qm.Opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.post(ctx, "/api/v1/reqvalidstorm", reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storm validation failed with status: %d", resp.StatusCode)
+	}
+
+	var response map[string]interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	status, detail := "valid", ""
+	if response["status"] != "ok" {
+		status = "invalid"
+		detail, _ = response["mesg"].(string)
+		if code, ok := response["code"].(string); ok && code != "" {
+			detail = code + ": " + detail
+		}
+	}
+
+	frame := data.NewFrame("explain",
+		data.NewField("operation", nil, []string{"parse"}),
+		data.NewField("status", nil, []string{status}),
+		data.NewField("detail", nil, []string{detail}),
+	)
+	frame.RefID = refID
+	frame.AppendNotices(data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     "the Cortex does not expose query plans or cost estimates; only query validity is reported",
+	})
+	return data.Frames{frame}, nil
+}
+
+// countPrintPattern matches the print message of the Storm count command
+var countPrintPattern = regexp.MustCompile(`Counted (\d+) nodes`)
+
+// parseCountStream reads the count from a "| count" query's print message,
+// falling back to the fini message count, and returns a single-value frame
+func parseCountStream(body io.Reader, query string, refID string) (data.Frames, error) {
+	var count int64
+	found := false
+
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	for {
+		var msg StormMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, errStreamIdle) {
+				return nil, err
+			}
+			break
+		}
+		if len(msg) < 2 {
+			continue
+		}
+
+		info, _ := msg[1].(map[string]interface{})
+		switch msg[0] {
+		case "print":
+			if mesg, ok := info["mesg"].(string); ok {
+				if match := countPrintPattern.FindStringSubmatch(mesg); match != nil {
+					count, _ = strconv.ParseInt(match[1], 10, 64)
+					found = true
+				}
+			}
+		case "err":
+			if errData, ok := msg[1].([]interface{}); ok && len(errData) >= 2 {
+				return nil, stormError(errData, query)
+			}
+		case "fini":
+			if !found {
+				if num, ok := info["count"].(json.Number); ok {
+					count, _ = num.Int64()
+				}
+			}
+			goto done
+		}
+	}
+done:
+
+	frame := data.NewFrame("count",
+		data.NewField("count", nil, []int64{count}),
+	)
+	frame.RefID = refID
+	return data.Frames{frame}, nil
+}
+
+// authDenyError is a Storm AuthDeny error: the querying user lacks a
+// permission the query needs
+type authDenyError struct {
+	mesg string
+	perm string // the required permission, when Synapse reports it
+}
+
+func (e *authDenyError) Error() string {
+	if e.perm != "" {
+		return fmt.Sprintf("permission denied: %s (requires %s)", e.mesg, e.perm)
+	}
+	return "permission denied: " + e.mesg
+}
+
+// permString renders a permission given as a dotted string or as a list of
+// parts, e.g. ["node", "add", "inet:ipv4"]
+func permString(perm interface{}) string {
+	switch v := perm.(type) {
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = fmt.Sprintf("%v", part)
+		}
+		return strings.Join(parts, ".")
+	}
+	return ""
+}
+
+// stormError formats the [name, info] body of a Storm err message. When info
+// locates the error in the query, the error shows the position and the query
+// line with a caret under the offending column:
+//
+//	storm error: BadSyntax: Unexpected token (line 2, column 7)
+//	  | inet:fqdn=
+//	  |       ^
+func stormError(errData []interface{}, query string) error {
+	info, ok := errData[1].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("storm error: %v", errData[1])
+	}
+	mesg, ok := info["mesg"].(string)
+	if !ok {
+		return fmt.Errorf("storm error: %v", errData[1])
+	}
+	name, _ := errData[0].(string)
+	if name == "AuthDeny" {
+		return &authDenyError{mesg: mesg, perm: permString(info["perm"])}
+	}
+	if name != "" {
+		mesg = name + ": " + mesg
+	}
+
+	// Positions come as line/column, or as a highlight with 1-based
+	// lines and columns ranges
+	line, hasLine := numericValue(info["line"])
+	column, hasColumn := numericValue(info["column"])
+	if highlight, ok := info["highlight"].(map[string]interface{}); ok && !(hasLine && hasColumn) {
+		if lines, ok := highlight["lines"].([]interface{}); ok && len(lines) > 0 {
+			line, hasLine = numericValue(lines[0])
+		}
+		if columns, ok := highlight["columns"].([]interface{}); ok && len(columns) > 0 {
+			column, hasColumn = numericValue(columns[0])
+		}
+	}
+	if !hasLine || !hasColumn {
+		return fmt.Errorf("storm error: %s", mesg)
+	}
+
+	text := fmt.Sprintf("storm error: %s (line %d, column %d)", mesg, int(line), int(column))
+	lines := strings.Split(query, "\n")
+	if int(line) >= 1 && int(line) <= len(lines) && int(column) >= 1 {
+		queryLine := lines[int(line)-1]
+		text += fmt.Sprintf("\n  | %s\n  | %s^", queryLine, strings.Repeat(" ", int(column)-1))
+	}
+	return errors.New(text)
+}
+
+// parseStormStream decodes a Storm message stream and builds the result frames
+func (d *Datasource) parseStormStream(body io.Reader, qm QueryModel, refID string) (data.Frames, error) {
+	// Parse streaming response - collect all nodes first
+	type NodeRecord struct {
+		Form  string
+		Value string
+		Iden  string
+		Tags  string
+		Ndef  string
+		// RawValue is the primary value as decoded, before formatting
+		RawValue interface{}
+		// TagList holds the individual tag names joined in Tags
+		TagList []string
+		Raw     string
+		// TagCategory is the first tag under QueryModel.ColorTagPrefix
+		TagCategory string
+		PropsJSON   string
+		Layer       string
+		Provenance  *string
+		Props       map[string]interface{}
+	}
+	var nodes []NodeRecord
+	allPropKeys := make(map[string]bool)
+	stats := streamStats{msgCounts: make(map[string]int64)}
+	var tick float64
+	hasTick := false
+	var edges []stormEdge
+	var edits editCounts
+	// dropped holds the idens of nodes removed by the post filters
+	dropped := make(map[string]bool)
+	keep := func(node NodeRecord) bool {
+		return d.matchPostFilters(qm.postFilters, func(field string) (interface{}, bool) {
+			switch field {
+			case "form":
+				return node.Form, true
+			case "value":
+				if node.RawValue != nil {
+					return node.RawValue, true
+				}
+				return node.Value, true
+			case "iden":
+				return node.Iden, true
+			case "tags":
+				return node.TagList, true
+			}
+			val, ok := node.Props[field]
+			return val, ok
+		})
+	}
+
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	for {
+		var msg StormMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				// The decoder buffers across chunk boundaries, so this is
+				// only reached when the stream ends inside a message
+				log.DefaultLogger.Warn("Storm stream ended mid-message", "error", err)
+				stats.cutOff = true
+				break
+			}
+			if errors.Is(err, errStreamIdle) {
+				return nil, err
+			}
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				// Syntax and read errors stick to the decoder, so retrying
+				// would fail forever
+				return nil, fmt.Errorf("decode storm stream: %w", err)
+			}
+			// A single JSON object instead of a message stream means the
+			// endpoint returned a storm/call style response
+			if typeErr.Value == "object" && len(stats.msgCounts) == 0 {
+				return nil, fmt.Errorf("storm query returned a single JSON object instead of a message stream; try enabling Use Call")
+			}
+			// The mistyped value was consumed, so decoding can continue
+			log.DefaultLogger.Warn("Error decoding storm message", "error", err)
+			continue
+		}
+
+		if len(msg) < 2 {
+			continue
+		}
+
+		msgType, ok := msg[0].(string)
+		if !ok {
+			continue
+		}
+		stats.msgCounts[msgType]++
+
+		switch msgType {
+		case "node":
+			if qm.MaxNodes > 0 && len(nodes) >= qm.MaxNodes {
+				// Stop reading once more nodes arrive than requested
+				stats.truncated = true
+				goto done
+			}
+
+			// Parse node structure: ["node", [[form, value], {props}]]
+			if nodeData, ok := msg[1].([]interface{}); ok && len(nodeData) >= 2 {
+				node := NodeRecord{
+					Props: make(map[string]interface{}),
+				}
+
+				if qm.IncludeRawNode {
+					if rawBytes, err := json.Marshal(nodeData); err == nil {
+						node.Raw = string(rawBytes)
+					}
+				}
+
+				if nodeDef, ok := nodeData[0].([]interface{}); ok && len(nodeDef) >= 2 {
+					if form, ok := nodeDef[0].(string); ok {
+						nodeInfo, _ := nodeData[1].(map[string]interface{})
+						node.Form = form
+						node.Value = d.nodeValueString(form, nodeDef[1], nodeInfo)
+						node.RawValue = nodeDef[1]
+						node.Ndef = ndefString(form, nodeDef[1])
+					}
+				} else {
+					log.DefaultLogger.Debug("Node message has an unexpected ndef shape", "message", msg)
+				}
+
+				if nodeProps, ok := nodeData[1].(map[string]interface{}); ok {
+					if iden, ok := nodeProps["iden"].(string); ok {
+						node.Iden = iden
+					}
+
+					if qm.IncludeLayer {
+						node.Layer = nodeLayer(nodeProps)
+					}
+
+					if qm.IncludeProvenance {
+						node.Provenance = d.nodeProvenance(nodeProps)
+					}
+
+					if qm.WithEdges {
+						edges = append(edges, nodeEdges(node.Iden, nodeProps)...)
+					}
+
+					// Extract tags
+					if nodeTags, ok := nodeProps["tags"].(map[string]interface{}); ok {
+						var tagList []string
+						for tag := range nodeTags {
+							tagList = append(tagList, tag)
+						}
+						node.Tags = d.tagsString(tagList)
+						node.TagList = tagList
+
+						if qm.ColorTagPrefix != "" {
+							node.TagCategory = firstTagWithPrefix(tagList, qm.ColorTagPrefix)
+						}
+					}
+
+					if qm.PropsAsJSON {
+						props, ok := nodeProps["props"].(map[string]interface{})
+						if !ok {
+							props = map[string]interface{}{}
+						}
+						node.PropsJSON = d.valueToString(props)
+					} else if props, ok := nodeProps["props"].(map[string]interface{}); ok {
+						// Extract all properties
+						for k, v := range props {
+							node.Props[k] = v
+						}
+					}
+
+					// Also add reprs if present for better readability
+					if reprs, ok := nodeProps["reprs"].(map[string]interface{}); ok && !qm.PropsAsJSON {
+						for k, v := range reprs {
+							// Store repr values with _repr suffix, JSON-encoding
+							// nested reprs such as ival ranges
+							reprKey := k + "_repr"
+							switch v.(type) {
+							case map[string]interface{}, []interface{}:
+								node.Props[reprKey] = d.valueToString(v)
+							default:
+								node.Props[reprKey] = v
+							}
+						}
+					}
+				}
+
+				// Filter as nodes arrive so MaxNodes counts only kept nodes
+				if len(qm.postFilters) > 0 && !keep(node) {
+					dropped[node.Iden] = true
+					continue
+				}
+				for key := range node.Props {
+					allPropKeys[key] = true
+				}
+				nodes = append(nodes, node)
+			} else {
+				// Likely a Synapse version with a different node shape
+				log.DefaultLogger.Debug("Skipping node message with an unexpected shape", "message", msg)
+				stats.malformed++
+			}
+		case "node:edits":
+			if qm.EditSummary {
+				edits.add(msg[1])
+			}
+		case "warn":
+			info, _ := msg[1].(map[string]interface{})
+			if mesg, ok := deprecationWarning(info); ok {
+				if d.config.FailOnDeprecation {
+					return nil, fmt.Errorf("storm deprecation warning: %s", mesg)
+				}
+				stats.deprecations = append(stats.deprecations, mesg)
+			}
+		case "init":
+			if info, ok := msg[1].(map[string]interface{}); ok {
+				tick, hasTick = numericValue(info["tick"])
+			}
+		case "err":
+			// Handle error message
+			if errData, ok := msg[1].([]interface{}); ok && len(errData) >= 2 {
+				return nil, stormError(errData, qm.StormQuery)
+			}
+		case "fini":
+			// Query finished
+			if info, ok := msg[1].(map[string]interface{}); ok {
+				if took, ok := numericValue(info["took"]); ok {
+					stats.serverElapsed = &took
+				} else if tock, ok := numericValue(info["tock"]); ok && hasTick {
+					elapsed := tock - tick
+					stats.serverElapsed = &elapsed
+				}
+			}
+			goto done
+		}
+	}
+done:
+
+	if len(dropped) > 0 {
+		// Drop the edges of filtered out nodes so the graph has no dangling
+		// ends; edges to nodes outside the result are kept as usual
+		keptEdges := edges[:0]
+		for _, edge := range edges {
+			if !dropped[edge.Source] && !dropped[edge.Target] {
+				keptEdges = append(keptEdges, edge)
+			}
+		}
+		edges = keptEdges
+	}
+
+	if qm.EditSummary {
+		return stormFrames(edits.frame(refID), qm, stats, refID), nil
+	}
+
+	if qm.TagRatePrefix != "" {
+		var created []time.Time
+		var tagLists [][]string
+		for _, node := range nodes {
+			if t := d.parseTimeValue(node.Props[".created"]); t != nil {
+				created = append(created, *t)
+				tagLists = append(tagLists, node.TagList)
+			}
+		}
+
+		frame := tagRateFrame(created, tagLists, qm.TagRatePrefix, qm.timeRange, qm.interval, refID)
+		return stormFrames(frame, qm, stats, refID), nil
+	}
+
+	if qm.CreationRate {
+		created := make([]time.Time, 0, len(nodes))
+		for _, node := range nodes {
+			if t := d.parseTimeValue(node.Props[".created"]); t != nil {
+				created = append(created, *t)
+			}
+		}
+
+		frame := creationRateFrame(created, qm.timeRange, qm.interval, refID)
+		return stormFrames(frame, qm, stats, refID), nil
+	}
+
+	if qm.LogsMode {
+		// Logs format: timestamp from .created, body from the node, labels
+		// from the form and tags. Nodes without .created are skipped.
+		var timestamps []time.Time
+		var bodies []string
+		var labels []json.RawMessage
+		for _, node := range nodes {
+			created := d.parseTimeValue(node.Props[".created"])
+			if created == nil {
+				continue
+			}
+			nodeLabels := map[string]string{"form": node.Form}
+			for _, tag := range node.TagList {
+				nodeLabels[tag] = "true"
+			}
+			labelBytes, err := json.Marshal(nodeLabels)
+			if err != nil {
+				return nil, fmt.Errorf("marshal labels: %w", err)
+			}
+			timestamps = append(timestamps, *created)
+			bodies = append(bodies, fmt.Sprintf("%s=%s", node.Form, node.Value))
+			labels = append(labels, labelBytes)
+		}
+
+		frame := data.NewFrame("logs",
+			data.NewField("timestamp", nil, timestamps),
+			data.NewField("body", nil, bodies),
+			data.NewField("labels", nil, labels),
+		)
+		frame.RefID = refID
+		frame.SetMeta(&data.FrameMeta{
+			Type:                   data.FrameTypeLogLines,
+			PreferredVisualization: data.VisTypeLogs,
+		})
+		return stormFrames(frame, qm, stats, refID), nil
+	}
+
+	if qm.TagTree {
+		var tagNames []string
+		for _, node := range nodes {
+			if node.Form == "syn:tag" {
+				// Ndef keeps the raw tag name regardless of value formatters
+				tagNames = append(tagNames, strings.TrimPrefix(node.Ndef, "syn:tag="))
+			}
+		}
+
+		frame := tagTreeFrame(tagNames, refID)
+		return stormFrames(frame, qm, stats, refID), nil
+	}
+
+	if qm.TopN != nil && qm.TopN.Prop != "" {
+		var values []string
+		for _, node := range nodes {
+			switch qm.TopN.Prop {
+			case "form":
+				values = append(values, node.Form)
+			case "value":
+				values = append(values, node.Value)
+			case "tags":
+				values = append(values, node.TagList...)
+			default:
+				if val, ok := node.Props[qm.TopN.Prop]; ok && val != nil {
+					values = append(values, d.valueToString(val))
+				}
+			}
+		}
+
+		frame := topNFrame(values, qm.TopN.Count, refID)
+		return stormFrames(frame, qm, stats, refID), nil
+	}
+
+	if len(qm.TableColumns) > 0 {
+		// Fixed schema: exactly the requested columns, null where absent
+		frame := data.NewFrame("storm")
+		frame.RefID = refID
+		columns := qm.TableColumns
+		if qm.EnsureIden && !contains(columns, "iden") {
+			columns = append([]string{"iden"}, columns...)
+		}
+		for _, col := range columns {
+			values := make([]interface{}, len(nodes))
+			for i, node := range nodes {
+				switch col {
+				case "form":
+					values[i] = node.Form
+				case "value":
+					values[i] = node.Value
+				case "iden":
+					values[i] = node.Iden
+				case "tags":
+					values[i] = node.Tags
+				default:
+					values[i] = node.Props[col]
+				}
+			}
+			fieldType, ok := qm.FieldTypes[col]
+			if !ok {
+				fieldType = "string"
+			}
+			frame.Fields = append(frame.Fields, d.newTypedField(col, values, fieldType, true))
+		}
+		if qm.EnsureIden {
+			markKeyField(frame, "iden")
+		}
+
+		frames := stormFrames(frame, qm, stats, refID)
+		if qm.WithEdges {
+			frames = append(frames, edgesFrame(edges, refID))
+		}
+		return frames, nil
+	}
+
+	// Build data frame from collected nodes
+	frame := data.NewFrame("storm")
+	frame.RefID = refID
+
+	var timeFieldKeys []string
+	if len(nodes) > 0 {
+		// Create base columns
+		forms := make([]string, len(nodes))
+		values := make([]string, len(nodes))
+		rawValues := make([]interface{}, len(nodes))
+		idens := make([]string, len(nodes))
+		tags := make([]string, len(nodes))
+
+		for i, node := range nodes {
+			forms[i] = node.Form
+			values[i] = node.Value
+			rawValues[i] = node.RawValue
+			idens[i] = node.Iden
+			tags[i] = node.Tags
+		}
+
+		frame.Fields = append(frame.Fields,
+			d.formFields(forms, qm.FormLabels)...,
+		)
+		frame.Fields = append(frame.Fields,
+			valueField(values, rawValues, qm.ValueAsNumber),
+			data.NewField("iden", nil, idens),
+			data.NewField("tags", nil, tags),
+		)
+
+		if qm.IncludeNdef {
+			ndefs := make([]string, len(nodes))
+			for i, node := range nodes {
+				ndefs[i] = node.Ndef
+			}
+			frame.Fields = append(frame.Fields,
+				data.NewField("ndef", nil, ndefs),
+			)
+		}
+
+		if qm.IncludeRawNode {
+			raws := make([]string, len(nodes))
+			for i, node := range nodes {
+				raws[i] = node.Raw
+			}
+			frame.Fields = append(frame.Fields,
+				data.NewField("_raw", nil, raws),
+			)
+		}
+
+		if qm.IncludeLayer {
+			layers := make([]string, len(nodes))
+			for i, node := range nodes {
+				layers[i] = node.Layer
+			}
+			frame.Fields = append(frame.Fields,
+				data.NewField("layer", nil, layers),
+			)
+		}
+
+		if qm.IncludeProvenance {
+			provenance := make([]*string, len(nodes))
+			for i, node := range nodes {
+				provenance[i] = node.Provenance
+			}
+			frame.Fields = append(frame.Fields,
+				data.NewField("provenance", nil, provenance),
+			)
+		}
+
+		if qm.PropsAsJSON {
+			propsJSON := make([]string, len(nodes))
+			for i, node := range nodes {
+				propsJSON[i] = node.PropsJSON
+			}
+			frame.Fields = append(frame.Fields,
+				data.NewField("props", nil, propsJSON),
+			)
+		}
+
+		if qm.ColorTagPrefix != "" {
+			categories := make([]string, len(nodes))
+			for i, node := range nodes {
+				categories[i] = node.TagCategory
+			}
+			frame.Fields = append(frame.Fields,
+				data.NewField("_tag_category", nil, categories),
+			)
+		}
+
+		// Create sorted list of property keys
+		propKeys := make([]string, 0, len(allPropKeys))
+		for k := range allPropKeys {
+			propKeys = append(propKeys, k)
+		}
+		sort.Strings(propKeys)
+
+		var sparseKeys []string
+		if qm.MinPropCoverage > 0 {
+			propKeys, sparseKeys = splitByCoverage(propKeys, len(nodes), qm.MinPropCoverage, func(key string, i int) bool {
+				_, ok := nodes[i].Props[key]
+				return ok
+			})
+		}
+
+		// Add a column for each property
+		for _, propKey := range propKeys {
+			if fieldType, ok := qm.FieldTypes[propKey]; ok {
+				// Declared types take precedence over detection
+				values := make([]interface{}, len(nodes))
+				for i, node := range nodes {
+					values[i] = node.Props[propKey]
+				}
+				frame.Fields = append(frame.Fields,
+					d.newTypedField(propKey, values, fieldType, qm.DistinguishNull),
+				)
+				if fieldType == "time" {
+					timeFieldKeys = append(timeFieldKeys, propKey)
+				}
+				continue
+			}
+
+			if isDurationField(propKey) {
+				// Numeric durations get a numeric field so they can carry a
+				// unit; any others are handled as regular properties
+				values := make([]interface{}, len(nodes))
+				for i, node := range nodes {
+					values[i] = node.Props[propKey]
+				}
+				if fieldType := d.detectFieldType(values); fieldType == "int" || fieldType == "float" {
+					frame.Fields = append(frame.Fields,
+						d.newTypedField(propKey, values, fieldType, qm.DistinguishNull),
+					)
+					continue
+				}
+			}
+
+			isTime := isTimeField(propKey)
+
+			// Skip _repr fields for time columns since we're formatting them properly
+			if strings.HasSuffix(propKey, "_repr") && isTime {
+				continue
+			}
+
+			if isTime && !strings.HasSuffix(propKey, "_repr") {
+				// Handle as time field
+				timeValues := make([]*time.Time, len(nodes))
+				for i, node := range nodes {
+					if val, exists := node.Props[propKey]; exists {
+						if timeVal := d.parseTimeValue(val); timeVal != nil {
+							timeValues[i] = timeVal
+						}
+					}
+				}
+				frame.Fields = append(frame.Fields,
+					data.NewField(propKey, nil, timeValues),
+				)
+				timeFieldKeys = append(timeFieldKeys, propKey)
+			} else if qm.DistinguishNull {
+				// Handle as nullable string field so absent props stay null.
+				// The pointers share one backing slice rather than one
+				// allocation per row.
+				strValues := make([]string, len(nodes))
+				propValues := make([]*string, len(nodes))
+				for i := range nodes {
+					if val, exists := nodes[i].Props[propKey]; exists && val != nil {
+						strValues[i] = propString(val)
+						propValues[i] = &strValues[i]
+					}
+				}
+				frame.Fields = append(frame.Fields,
+					data.NewField(propKey, nil, propValues),
+				)
+			} else {
+				// Handle as string field
+				propValues := make([]string, len(nodes))
+				for i := range nodes {
+					if val, exists := nodes[i].Props[propKey]; exists {
+						propValues[i] = propString(val)
+					}
+				}
+				frame.Fields = append(frame.Fields,
+					data.NewField(propKey, nil, propValues),
+				)
+			}
+		}
+
+		if len(sparseKeys) > 0 {
+			sparse := make([]*string, len(nodes))
+			for i, node := range nodes {
+				props := make(map[string]interface{})
+				for _, key := range sparseKeys {
+					if val, ok := node.Props[key]; ok {
+						props[key] = val
+					}
+				}
+				if len(props) > 0 {
+					propsJSON := d.valueToString(props)
+					sparse[i] = &propsJSON
+				}
+			}
+			frame.Fields = append(frame.Fields,
+				data.NewField("_sparse", nil, sparse),
+			)
+		}
+	}
+
+	if len(qm.ComputedFields) > 0 && len(nodes) > 0 {
+		names := make([]string, 0, len(qm.ComputedFields))
+		for name := range qm.ComputedFields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			expr, err := compileExpr(qm.ComputedFields[name])
+			if err != nil {
+				return nil, fmt.Errorf("computed field %s: %w", name, err)
+			}
+
+			// Rows with missing or non-numeric inputs, or division by zero, are null
+			computed := make([]*float64, len(nodes))
+			for i, node := range nodes {
+				props := node.Props
+				if val, ok := expr.eval(func(ref string) (float64, bool) {
+					return numericValue(props[ref])
+				}); ok {
+					computed[i] = &val
+				}
+			}
+			frame.Fields = append(frame.Fields, data.NewField(name, nil, computed))
+		}
+	}
+
+	if d.config.AutoTimeField {
+		promoteTimeField(frame, timeFieldKeys)
+	}
+
+	if qm.EnsureIden {
+		if field, _ := frame.FieldByName("iden"); field == nil {
+			frame.Fields = append(frame.Fields, data.NewField("iden", nil, []string{}))
+		}
+		markKeyField(frame, "iden")
+	}
+
+	frames := stormFrames(frame, qm, stats, refID)
+	if qm.PartitionBy != "" && len(nodes) > 0 {
+		keys := make([]string, len(nodes))
+		for i, node := range nodes {
+			keys[i] = "unknown"
+			if val, ok := node.Props[qm.PartitionBy]; ok && val != nil {
+				keys[i] = fmt.Sprintf("%v", val)
+			}
+		}
+		frames = append(partitionFrame(frames[0], keys), frames[1:]...)
+	} else if qm.TagsAsLabels && len(nodes) > 0 {
+		tagLists := make([][]string, len(nodes))
+		for i, node := range nodes {
+			tagLists[i] = node.TagList
+		}
+		frames = append(d.tagLabelFrames(frames[0], tagLists), frames[1:]...)
+	}
+	if qm.WithEdges {
+		frames = append(frames, edgesFrame(edges, refID))
+	}
+
+	return frames, nil
+}
+
+// Synapse node edit types, as found in node:edits messages
+const (
+	editNodeAdd = 0
+	editNodeDel = 1
+	editPropSet = 2
+	editTagSet  = 4
+)
+
+// editCounts tallies the edits of a query's node:edits messages
+type editCounts struct {
+	nodesAdded   int64
+	propsSet     int64
+	tagsAdded    int64
+	nodesDeleted int64
+}
+
+// add counts the edits of a node:edits message body, shaped
+// {"edits": [[buid, form, [[type, info, ...], ...]], ...]}
+func (c *editCounts) add(body interface{}) {
+	info, _ := body.(map[string]interface{})
+	nodeEdits, _ := info["edits"].([]interface{})
+	for _, nodeEdit := range nodeEdits {
+		parts, ok := nodeEdit.([]interface{})
+		if !ok || len(parts) < 3 {
+			continue
+		}
+		edits, _ := parts[2].([]interface{})
+		for _, edit := range edits {
+			editParts, ok := edit.([]interface{})
+			if !ok || len(editParts) == 0 {
+				continue
+			}
+			editType, ok := numericValue(editParts[0])
+			if !ok {
+				continue
+			}
+			switch int(editType) {
+			case editNodeAdd:
+				c.nodesAdded++
+			case editNodeDel:
+				c.nodesDeleted++
+			case editPropSet:
+				c.propsSet++
+			case editTagSet:
+				c.tagsAdded++
+			}
+		}
+	}
+}
+
+// frame returns the edit counts as a single-row summary frame
+func (c *editCounts) frame(refID string) *data.Frame {
+	frame := data.NewFrame("edits",
+		data.NewField("nodes_added", nil, []int64{c.nodesAdded}),
+		data.NewField("props_set", nil, []int64{c.propsSet}),
+		data.NewField("tags_added", nil, []int64{c.tagsAdded}),
+		data.NewField("nodes_deleted", nil, []int64{c.nodesDeleted}),
+	)
+	frame.RefID = refID
+	return frame
+}
+
+// tagTreeFrame builds the tag hierarchy of dotted tag names, adding any
+// ancestors missing from names so every parent has a row. Root tags have an
+// empty parent and depth 0.
+func tagTreeFrame(names []string, refID string) *data.Frame {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		for name != "" && !seen[name] {
+			seen[name] = true
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				name = name[:i]
+			} else {
+				name = ""
+			}
+		}
+	}
+
+	tags := make([]string, 0, len(seen))
+	for tag := range seen {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	parents := make([]string, len(tags))
+	depths := make([]int64, len(tags))
+	for i, tag := range tags {
+		if j := strings.LastIndex(tag, "."); j >= 0 {
+			parents[i] = tag[:j]
+		}
+		depths[i] = int64(strings.Count(tag, "."))
+	}
+
+	frame := data.NewFrame("tags",
+		data.NewField("tag", nil, tags),
+		data.NewField("parent", nil, parents),
+		data.NewField("depth", nil, depths),
+	)
+	frame.RefID = refID
+	return frame
+}
+
+// splitByCoverage separates keys present in at least minCoverage of rows
+// from the rest, keeping their order. has reports whether row i has key.
+func splitByCoverage(keys []string, rows int, minCoverage float64, has func(key string, i int) bool) ([]string, []string) {
+	var covered, sparse []string
+	for _, key := range keys {
+		count := 0
+		for i := 0; i < rows; i++ {
+			if has(key, i) {
+				count++
+			}
+		}
+		if float64(count) >= minCoverage*float64(rows) {
+			covered = append(covered, key)
+		} else {
+			sparse = append(sparse, key)
+		}
+	}
+	return covered, sparse
+}
+
+// topNFrame tallies values and returns the n most frequent as a value/count
+// frame sorted by descending count, ties broken by value. n <= 0 keeps all.
+func topNFrame(values []string, n int, refID string) *data.Frame {
+	counts := make(map[string]int64)
+	for _, val := range values {
+		counts[val]++
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+
+	tallies := make([]int64, len(keys))
+	for i, key := range keys {
+		tallies[i] = counts[key]
+	}
+
+	frame := data.NewFrame("topN",
+		data.NewField("value", nil, keys),
+		data.NewField("count", nil, tallies),
+	)
+	frame.RefID = refID
+	return frame
+}
+
+// markKeyField flags the named field as the frame's row key in its custom
+// field config, and makes it filterable for cross-panel links
+func markKeyField(frame *data.Frame, name string) {
+	field, _ := frame.FieldByName(name)
+	if field == nil {
+		return
+	}
+	if field.Config == nil {
+		field.Config = &data.FieldConfig{}
+	}
+	field.Config.SetFilterable(true)
+	if field.Config.Custom == nil {
+		field.Config.Custom = make(map[string]interface{})
+	}
+	field.Config.Custom["key"] = true
+}
+
+// partitionFrame splits frame rows into one frame per distinct key, named by
+// the key. Frames are ordered by key with "unknown" last.
+func partitionFrame(frame *data.Frame, keys []string) data.Frames {
+	parts := make(map[string]*data.Frame)
+	var order []string
+	for row, key := range keys {
+		part, ok := parts[key]
+		if !ok {
+			part = frame.EmptyCopy()
+			part.Name = key
+			part.Meta = frame.Meta
+			for i, field := range frame.Fields {
+				part.Fields[i].Config = field.Config
+			}
+			parts[key] = part
+			order = append(order, key)
+		}
+		part.AppendRow(frame.RowCopy(row)...)
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		if order[i] == "unknown" || order[j] == "unknown" {
+			return order[j] == "unknown" && order[i] != "unknown"
+		}
+		return order[i] < order[j]
+	})
+
+	frames := make(data.Frames, len(order))
+	for i, key := range order {
+		frames[i] = parts[key]
+	}
+	return frames
+}
+
+// tagLabelPattern matches characters not valid in a label name
+var tagLabelPattern = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// tagLabelName sanitizes a tag name for use as a label name
+func (d *Datasource) tagLabelName(tag string) string {
+	name := tagLabelPattern.ReplaceAllString(tag, d.config.TagLabelReplacement)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// tagLabelFrames splits frame into one frame per distinct tag set, labelling
+// every field with the set's tags
+func (d *Datasource) tagLabelFrames(frame *data.Frame, tagLists [][]string) data.Frames {
+	keys := make([]string, len(tagLists))
+	labelSets := make(map[string]data.Labels)
+	for i, tagList := range tagLists {
+		labels := data.Labels{}
+		for _, tag := range tagList {
+			labels[d.tagLabelName(tag)] = "true"
+		}
+		keys[i] = labels.String()
+		labelSets[keys[i]] = labels
+	}
+
+	frames := partitionFrame(frame, keys)
+	for _, part := range frames {
+		labels := labelSets[part.Name]
+		part.Name = frame.Name
+		for _, field := range part.Fields {
+			field.Labels = labels.Copy()
+		}
+	}
+	return frames
+}
+
+// stormEdge is a graph edge between two nodes identified by iden
+type stormEdge struct {
+	Source string
+	Target string
+	Type   string // "edge" for light edges, "prop" for property references
+	Verb   string
+}
+
+// nodeEdges extracts edges from a graph-mode node's path.edges entries, each
+// shaped [targetIden, {"type": ..., "verb"|"prop": ...}]
+func nodeEdges(iden string, nodeInfo map[string]interface{}) []stormEdge {
+	path, ok := nodeInfo["path"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	pathEdges, ok := path["edges"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var edges []stormEdge
+	for _, item := range pathEdges {
+		edgeData, ok := item.([]interface{})
+		if !ok || len(edgeData) < 2 {
+			continue
+		}
+		target, ok := edgeData[0].(string)
 		if !ok {
 			continue
 		}
+		edge := stormEdge{Source: iden, Target: target}
+		if info, ok := edgeData[1].(map[string]interface{}); ok {
+			edge.Type, _ = info["type"].(string)
+			if verb, ok := info["verb"].(string); ok {
+				edge.Verb = verb
+			} else if prop, ok := info["prop"].(string); ok {
+				edge.Verb = prop
+			}
+		}
+		edges = append(edges, edge)
+	}
+	return edges
+}
+
+// edgesFrame builds the edges frame using node graph field names
+func edgesFrame(edges []stormEdge, refID string) *data.Frame {
+	ids := make([]string, len(edges))
+	sources := make([]string, len(edges))
+	targets := make([]string, len(edges))
+	types := make([]string, len(edges))
+	verbs := make([]string, len(edges))
+	for i, edge := range edges {
+		ids[i] = fmt.Sprintf("%s-%s-%s", edge.Source, edge.Verb, edge.Target)
+		sources[i] = edge.Source
+		targets[i] = edge.Target
+		types[i] = edge.Type
+		verbs[i] = edge.Verb
+	}
+
+	frame := data.NewFrame("edges",
+		data.NewField("id", nil, ids),
+		data.NewField("source", nil, sources),
+		data.NewField("target", nil, targets),
+		data.NewField("type", nil, types),
+		data.NewField("verb", nil, verbs),
+	)
+	frame.RefID = refID
+	return frame
+}
+
+// stormFrames completes a storm query result: it notes truncation or a cut
+// off stream on the primary frame and appends the message stats frame when requested
+func stormFrames(frame *data.Frame, qm QueryModel, stats streamStats, refID string) data.Frames {
+	if stats.truncated {
+		frame.AppendNotices(nodeLimitNotice(qm))
+	}
+	if stats.cutOff {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "storm stream ended mid-message; results may be incomplete",
+		})
+	}
+
+	if stats.serverElapsed != nil {
+		appendQueryStat(frame, "server_elapsed_ms", "ms", *stats.serverElapsed)
+	}
+	if stats.malformed > 0 {
+		appendQueryStat(frame, "malformed_nodes", "", float64(stats.malformed))
+	}
+	for _, mesg := range stats.deprecations {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "deprecated Storm: " + mesg,
+		})
+	}
+
+	frames := data.Frames{frame}
+	if qm.MessageStats {
+		frames = append(frames, messageStatsFrame(stats.msgCounts, refID))
+	}
+	return frames
+}
+
+// nodeLimitNotice notes results cut short by the query's MaxNodes, warning
+// when the limit is the datasource's GlobalMaxNodes
+func nodeLimitNotice(qm QueryModel) data.Notice {
+	if qm.globalCap {
+		return data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("results limited to %d nodes by the datasource node limit", qm.MaxNodes),
+		}
+	}
+	return data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("results limited to %d nodes", qm.MaxNodes),
+	}
+}
+
+// streamStats describes a decoded Storm message stream
+type streamStats struct {
+	truncated bool             // MaxNodes stopped the stream early
+	cutOff    bool             // the stream ended inside a message
+	msgCounts map[string]int64 // messages received per type
+	// serverElapsed is the Cortex execution time in milliseconds, from the
+	// fini took, or its tock less the init tick
+	serverElapsed *float64
+	malformed     int64 // node messages skipped for an unexpected shape
+	// deprecations holds the mesg of each deprecation warning
+	deprecations []string
+}
+
+// deprecationWarning returns the mesg of a warn message when it warns of
+// deprecated Storm, identified by its mesg or type
+func deprecationWarning(info map[string]interface{}) (string, bool) {
+	mesg, _ := info["mesg"].(string)
+	kind, _ := info["type"].(string)
+	if strings.Contains(strings.ToLower(mesg), "deprecat") || strings.Contains(strings.ToLower(kind), "deprecat") {
+		return mesg, true
+	}
+	return "", false
+}
+
+// appendQueryStat adds a stat to the frame meta, shown in the query inspector
+func appendQueryStat(frame *data.Frame, name string, unit string, value float64) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.Stats = append(frame.Meta.Stats, data.QueryStat{
+		FieldConfig: data.FieldConfig{DisplayName: name, Unit: unit},
+		Value:       value,
+	})
+}
+
+// maxRateBuckets bounds the number of buckets in a creation rate series
+const maxRateBuckets = 10000
+
+// creationRateFrame counts created times per interval bucket across the time
+// range, including empty buckets. The interval is widened if the range would
+// otherwise need more than maxRateBuckets buckets.
+func creationRateFrame(created []time.Time, timeRange backend.TimeRange, interval time.Duration, refID string) *data.Frame {
+	buckets, bucketOf := rateBuckets(timeRange, interval)
+
+	counts := make([]int64, len(buckets))
+	for _, t := range created {
+		if idx := bucketOf(t); idx >= 0 {
+			counts[idx]++
+		}
+	}
+
+	frame := data.NewFrame("creation_rate",
+		data.NewField("time", nil, buckets),
+		data.NewField("count", nil, counts),
+	)
+	frame.RefID = refID
+	return frame
+}
+
+// rateBuckets returns the interval bucket start times across the time range,
+// and a function giving the bucket of a time or -1 when outside the range
+func rateBuckets(timeRange backend.TimeRange, interval time.Duration) ([]time.Time, func(time.Time) int) {
+	span := timeRange.To.Sub(timeRange.From)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if span > 0 && span/interval > maxRateBuckets {
+		interval = span / maxRateBuckets
+	}
+
+	start := timeRange.From.Truncate(interval)
+	var buckets []time.Time
+	for t := start; !t.After(timeRange.To); t = t.Add(interval) {
+		buckets = append(buckets, t)
+	}
+
+	return buckets, func(t time.Time) int {
+		if t.Before(start) || t.After(timeRange.To) {
+			return -1
+		}
+		idx := int(t.Sub(start) / interval)
+		if idx >= len(buckets) {
+			return -1
+		}
+		return idx
+	}
+}
+
+// tagRateFrame counts created times per interval bucket like
+// creationRateFrame, with one count field per tag directly under prefix.
+// Nodes are counted in each such tag they bear.
+func tagRateFrame(created []time.Time, tagLists [][]string, prefix string, timeRange backend.TimeRange, interval time.Duration, refID string) *data.Frame {
+	buckets, bucketOf := rateBuckets(timeRange, interval)
+	prefix = strings.TrimSuffix(strings.TrimPrefix(prefix, "#"), ".") + "."
+
+	counts := make(map[string][]int64)
+	for i, t := range created {
+		idx := bucketOf(t)
+		if idx < 0 {
+			continue
+		}
+		for _, tag := range tagLists[i] {
+			if !strings.HasPrefix(tag, prefix) || strings.Contains(tag[len(prefix):], ".") {
+				continue
+			}
+			if counts[tag] == nil {
+				counts[tag] = make([]int64, len(buckets))
+			}
+			counts[tag][idx]++
+		}
+	}
+
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	frame := data.NewFrame("tag_rate", data.NewField("time", nil, buckets))
+	for _, tag := range tags {
+		frame.Fields = append(frame.Fields, data.NewField(tag, nil, counts[tag]))
+	}
+	frame.RefID = refID
+	return frame
+}
+
+// stormMessageTypes lists the Storm message types always reported by
+// messageStatsFrame, in display order
+var stormMessageTypes = []string{"node", "edge", "print", "warn", "err", "node:edits", "fini"}
+
+// messageStatsFrame builds a frame with the count of each message type seen.
+// Known types are always listed; any others follow in sorted order.
+func messageStatsFrame(msgCounts map[string]int64, refID string) *data.Frame {
+	types := append([]string{}, stormMessageTypes...)
+	var others []string
+	for msgType := range msgCounts {
+		known := false
+		for _, t := range stormMessageTypes {
+			if t == msgType {
+				known = true
+				break
+			}
+		}
+		if !known {
+			others = append(others, msgType)
+		}
+	}
+	sort.Strings(others)
+	types = append(types, others...)
 
-		switch msgType {
-		case "node":
-			// Parse node structure: ["node", [[form, value], {props}]]
-			if nodeData, ok := msg[1].([]interface{}); ok && len(nodeData) >= 2 {
-				node := NodeRecord{
-					Props: make(map[string]interface{}),
-				}
+	counts := make([]int64, len(types))
+	for i, msgType := range types {
+		counts[i] = msgCounts[msgType]
+	}
 
-				if nodeDef, ok := nodeData[0].([]interface{}); ok && len(nodeDef) >= 2 {
-					if form, ok := nodeDef[0].(string); ok {
-						node.Form = form
-						node.Value = fmt.Sprintf("%v", nodeDef[1])
-					}
-				}
+	frame := data.NewFrame("message_stats",
+		data.NewField("type", nil, types),
+		data.NewField("count", nil, counts),
+	)
+	frame.RefID = refID
+	return frame
+}
 
-				if nodeProps, ok := nodeData[1].(map[string]interface{}); ok {
-					if iden, ok := nodeProps["iden"].(string); ok {
-						node.Iden = iden
-					}
+// numericValue converts a decoded JSON number or numeric string to float64
+func numericValue(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
 
-					// Extract tags
-					if nodeTags, ok := nodeProps["tags"].(map[string]interface{}); ok {
-						var tagList []string
-						for tag := range nodeTags {
-							tagList = append(tagList, tag)
-						}
-						node.Tags = strings.Join(tagList, ", ")
-					}
+// isDurationField reports whether a property holds a duration by its name,
+// e.g. duration or :duration
+func isDurationField(key string) bool {
+	return strings.HasSuffix(strings.ToLower(key), "duration")
+}
 
-					// Extract all properties
-					if props, ok := nodeProps["props"].(map[string]interface{}); ok {
-						for k, v := range props {
-							node.Props[k] = v
-							allPropKeys[k] = true
-						}
-					}
+// applyDurationUnits sets Config.DurationUnit on numeric duration fields
+func (d *Datasource) applyDurationUnits(frames data.Frames) {
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if !isDurationField(field.Name) || !field.Type().Numeric() {
+				continue
+			}
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			field.Config.Unit = d.config.DurationUnit
+		}
+	}
+}
 
-					// Also add reprs if present for better readability
-					if reprs, ok := nodeProps["reprs"].(map[string]interface{}); ok {
-						for k, v := range reprs {
-							// Store repr values with _repr suffix
-							reprKey := k + "_repr"
-							node.Props[reprKey] = v
-							allPropKeys[reprKey] = true
-						}
-					}
-				}
+// isTimeField reports whether a column name looks like it holds a time value
+func isTimeField(key string) bool {
+	lowerKey := strings.ToLower(key)
+	return strings.Contains(lowerKey, "created") ||
+		strings.Contains(lowerKey, "seen") ||
+		strings.Contains(lowerKey, "time") ||
+		strings.Contains(lowerKey, "modified") ||
+		strings.Contains(lowerKey, "updated") ||
+		strings.Contains(lowerKey, "accessed") ||
+		strings.Contains(lowerKey, "published") ||
+		strings.Contains(lowerKey, "date") ||
+		strings.Contains(lowerKey, "timestamp")
+}
 
-				nodes = append(nodes, node)
-			}
-		case "err":
-			// Handle error message
-			if errData, ok := msg[1].([]interface{}); ok && len(errData) >= 2 {
-				return nil, fmt.Errorf("storm error: %v", errData[1])
+// promoteTimeField moves the time field to the front of the frame. With a
+// single candidate it is used directly; with several, .created is preferred
+// and nothing is moved when it is absent.
+func promoteTimeField(frame *data.Frame, timeFieldKeys []string) {
+	var promote string
+	switch {
+	case len(timeFieldKeys) == 1:
+		promote = timeFieldKeys[0]
+	case len(timeFieldKeys) > 1:
+		for _, key := range timeFieldKeys {
+			if key == ".created" {
+				promote = key
 			}
-		case "fini":
-			// Query finished
-			goto done
 		}
 	}
-done:
+	if promote == "" {
+		return
+	}
 
-	// Build data frame from collected nodes
-	frame := data.NewFrame("storm")
-	frame.RefID = refID
+	for i, field := range frame.Fields {
+		if field.Name == promote {
+			fields := append([]*data.Field{field}, frame.Fields[:i]...)
+			frame.Fields = append(fields, frame.Fields[i+1:]...)
+			return
+		}
+	}
+}
 
-	if len(nodes) > 0 {
-		// Create base columns
-		forms := make([]string, len(nodes))
-		values := make([]string, len(nodes))
-		idens := make([]string, len(nodes))
-		tags := make([]string, len(nodes))
+// formFields builds the form column, applying Config.FormDisplayNames either
+// in place or, with companion set, as an additional form_label column
+func (d *Datasource) formFields(forms []string, companion bool) []*data.Field {
+	if len(d.config.FormDisplayNames) == 0 {
+		return []*data.Field{data.NewField("form", nil, forms)}
+	}
 
-		for i, node := range nodes {
-			forms[i] = node.Form
-			values[i] = node.Value
-			idens[i] = node.Iden
-			tags[i] = node.Tags
+	labels := make([]string, len(forms))
+	for i, form := range forms {
+		labels[i] = form
+		if label, ok := d.config.FormDisplayNames[form]; ok {
+			labels[i] = label
 		}
+	}
 
-		frame.Fields = append(frame.Fields,
+	if companion {
+		return []*data.Field{
 			data.NewField("form", nil, forms),
-			data.NewField("value", nil, values),
-			data.NewField("iden", nil, idens),
-			data.NewField("tags", nil, tags),
-		)
+			data.NewField("form_label", nil, labels),
+		}
+	}
+	return []*data.Field{data.NewField("form", nil, labels)}
+}
 
-		// Create sorted list of property keys
-		propKeys := make([]string, 0, len(allPropKeys))
-		for k := range allPropKeys {
-			propKeys = append(propKeys, k)
+// ndefString renders a node definition as form=value, the Storm lift syntax,
+// using the raw primary value so it is stable across formatting options
+func ndefString(form string, val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return form
+	case []interface{}, map[string]interface{}:
+		jsonBytes, err := json.Marshal(v)
+		if err == nil {
+			return form + "=" + string(jsonBytes)
 		}
-		sort.Strings(propKeys)
+	}
+	return fmt.Sprintf("%s=%v", form, val)
+}
 
-		// Add a column for each property
-		for _, propKey := range propKeys {
-			// Check if this is a time field - be more inclusive
-			lowerKey := strings.ToLower(propKey)
-			isTimeField := strings.Contains(lowerKey, "created") ||
-				strings.Contains(lowerKey, "seen") ||
-				strings.Contains(lowerKey, "time") ||
-				strings.Contains(lowerKey, "modified") ||
-				strings.Contains(lowerKey, "updated") ||
-				strings.Contains(lowerKey, "accessed") ||
-				strings.Contains(lowerKey, "published") ||
-				strings.Contains(lowerKey, "date") ||
-				strings.Contains(lowerKey, "timestamp")
+// nodeValueString renders a node primary value for the value column. Null
+// primary values (guid forms, edge-only nodes) use Config.NullValue rather
+// than Go's <nil> literal. Composite values prefer the node's primary repr
+// from nodeInfo and are otherwise JSON-encoded. A Config.ValueFormatters
+// entry for the form overrides this:
+//
+//	raw          the value itself, ignoring reprs
+//	repr         the primary repr when present
+//	truncate:N   the default rendering cut to N characters
+func (d *Datasource) nodeValueString(form string, val interface{}, nodeInfo map[string]interface{}) string {
+	formatter := d.config.ValueFormatters[form]
+	switch {
+	case formatter == "raw":
+		if val == nil {
+			return d.config.NullValue
+		}
+		return d.valueToString(val)
+	case formatter == "repr":
+		if repr, ok := nodeInfo["repr"].(string); ok && repr != "" {
+			return repr
+		}
+	case strings.HasPrefix(formatter, "truncate:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(formatter, "truncate:"))
+		str := []rune(d.defaultNodeValueString(val, nodeInfo))
+		if err == nil && n >= 0 && len(str) > n {
+			return string(str[:n]) + "…"
+		}
+		return string(str)
+	}
+	return d.defaultNodeValueString(val, nodeInfo)
+}
 
-			// Skip _repr fields for time columns since we're formatting them properly
-			if strings.HasSuffix(propKey, "_repr") && isTimeField {
-				continue
-			}
+// defaultNodeValueString renders a node primary value without formatters.
+// Booleans render as true/false and null primaries as Config.NullValue.
+func (d *Datasource) defaultNodeValueString(val interface{}, nodeInfo map[string]interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return d.config.NullValue
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}, map[string]interface{}:
+		if repr, ok := nodeInfo["repr"].(string); ok && repr != "" {
+			return repr
+		}
+		return d.valueToString(val)
+	}
+	return fmt.Sprintf("%v", val)
+}
 
-			if isTimeField && !strings.HasSuffix(propKey, "_repr") {
-				// Handle as time field
-				timeValues := make([]*time.Time, len(nodes))
-				for i, node := range nodes {
-					if val, exists := node.Props[propKey]; exists {
-						if timeVal := d.parseTimeValue(val); timeVal != nil {
-							timeValues[i] = timeVal
-						}
-					}
-				}
-				frame.Fields = append(frame.Fields,
-					data.NewField(propKey, nil, timeValues),
-				)
-			} else {
-				// Handle as string field
-				propValues := make([]string, len(nodes))
-				for i, node := range nodes {
-					if val, exists := node.Props[propKey]; exists {
-						propValues[i] = fmt.Sprintf("%v", val)
-					} else {
-						propValues[i] = ""
-					}
-				}
-				frame.Fields = append(frame.Fields,
-					data.NewField(propKey, nil, propValues),
-				)
-			}
+// nodeLayer returns the layer iden from the node info, either set directly
+// or within the node path, or an empty string when unavailable
+func nodeLayer(nodeInfo map[string]interface{}) string {
+	if layer, ok := nodeInfo["layer"].(string); ok {
+		return layer
+	}
+	if path, ok := nodeInfo["path"].(map[string]interface{}); ok {
+		if layer, ok := path["layer"].(string); ok {
+			return layer
 		}
 	}
+	return ""
+}
 
-	return data.Frames{frame}, nil
+// nodeProvenance returns the provenance from the node info, set directly or
+// as the .prov prop, or nil when unavailable. Provenance stacks are rendered
+// as JSON.
+func (d *Datasource) nodeProvenance(nodeInfo map[string]interface{}) *string {
+	prov, ok := nodeInfo["prov"]
+	if !ok {
+		if props, isMap := nodeInfo["props"].(map[string]interface{}); isMap {
+			prov, ok = props[".prov"]
+		}
+	}
+	if !ok || prov == nil {
+		return nil
+	}
+	provStr := d.valueToString(prov)
+	return &provStr
+}
+
+// tagsString joins tag names sorted, truncated to Config.MaxTagsPerNode
+func (d *Datasource) tagsString(tagList []string) string {
+	sorted := append([]string(nil), tagList...)
+	sort.Strings(sorted)
+	if limit := d.config.MaxTagsPerNode; limit > 0 && len(sorted) > limit {
+		return fmt.Sprintf("%s +%d more", strings.Join(sorted[:limit], ", "), len(sorted)-limit)
+	}
+	return strings.Join(sorted, ", ")
+}
+
+// firstTagWithPrefix returns the alphabetically first tag equal to or nested
+// under prefix, or an empty string when none match
+func firstTagWithPrefix(tags []string, prefix string) string {
+	prefix = strings.TrimSuffix(strings.TrimPrefix(prefix, "#"), ".")
+	var matches []string
+	for _, tag := range tags {
+		if tag == prefix || strings.HasPrefix(tag, prefix+".") {
+			matches = append(matches, tag)
+		}
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Strings(matches)
+	return matches[0]
 }
 
 // parseTimeValueFromString attempts to parse a string value as time
@@ -439,31 +2912,68 @@ func (d *Datasource) parseTimeValueFromString(val string) *time.Time {
 
 	// Try to parse as number first (epoch time)
 	if numVal, err := strconv.ParseFloat(val, 64); err == nil {
-		if numVal > 1e12 && numVal < 2e12 {
-			// Likely milliseconds
-			t := time.Unix(0, int64(numVal)*1e6)
-			return &t
-		} else if numVal > 1e9 && numVal < 2e9 {
-			// Likely seconds
-			t := time.Unix(int64(numVal), 0)
-			return &t
+		if t := d.epochTime(numVal); t != nil {
+			return t
 		}
 	}
 
-	// Try ISO formats
-	if t, err := time.Parse(time.RFC3339, val); err == nil {
-		return &t
-	}
-	if t, err := time.Parse("2006-01-02T15:04:05.000Z", val); err == nil {
-		return &t
-	}
-	if t, err := time.Parse("2006-01-02T15:04:05Z", val); err == nil {
-		return &t
+	return parseTimeLayouts(val)
+}
+
+// epochTime converts a numeric epoch time using Config.TimeUnit. An
+// explicit unit always applies.
+//
+// In auto mode values at or below 1e9 are not treated as times, so small
+// counts stay numbers, and the unit is chosen by magnitude: below 1e11 is
+// seconds (up to year 5138), below 1e14 is milliseconds, Synapse's native
+// unit (recent dates are around 1e12 to 1e13), and anything larger is
+// microseconds.
+func (d *Datasource) epochTime(v float64) *time.Time {
+	unit := d.config.TimeUnit
+	if unit == "" || unit == "auto" {
+		switch {
+		case v <= 1e9:
+			return nil
+		case v < 1e11:
+			unit = "s"
+		case v < 1e14:
+			unit = "ms"
+		default:
+			unit = "us"
+		}
 	}
-	if t, err := time.Parse("2006-01-02 15:04:05", val); err == nil {
-		return &t
+
+	var t time.Time
+	switch unit {
+	case "s":
+		t = time.Unix(int64(v), 0)
+	case "us":
+		t = time.UnixMicro(int64(v))
+	default:
+		t = time.UnixMilli(int64(v))
 	}
+	return &t
+}
+
+// timeLayouts are the string time formats accepted by parseTimeValue and
+// parseTimeValueFromString, including Synapse's slash-delimited repr format
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05.000Z",
+	"2006-01-02T15:04:05Z",
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05.000",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+}
 
+// parseTimeLayouts parses val with the first matching time layout
+func parseTimeLayouts(val string) *time.Time {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, val); err == nil {
+			return &t
+		}
+	}
 	return nil
 }
 
@@ -471,28 +2981,19 @@ func (d *Datasource) parseTimeValueFromString(val string) *time.Time {
 func (d *Datasource) parseTimeValue(val interface{}) *time.Time {
 	switch v := val.(type) {
 	case float64:
-		// Synapse times are in milliseconds since epoch
-		// Values like 1757289600 are Synapse milliseconds (not Unix seconds)
-		if v > 1e9 {
-			// Treat as milliseconds
-			t := time.Unix(0, int64(v)*1e6)
-			return &t
-		}
+		return d.epochTime(v)
 	case int64:
-		if v > 1e9 {
-			// Treat as milliseconds
-			t := time.Unix(0, v*1e6)
-			return &t
-		}
-	case string:
-		// Try to parse ISO format
-		if t, err := time.Parse(time.RFC3339, v); err == nil {
-			return &t
+		return d.epochTime(float64(v))
+	case json.Number:
+		if intVal, err := v.Int64(); err == nil {
+			return d.parseTimeValue(intVal)
 		}
-		// Try other common formats
-		if t, err := time.Parse("2006-01-02T15:04:05.000Z", v); err == nil {
-			return &t
+		if floatVal, err := v.Float64(); err == nil {
+			return d.parseTimeValue(floatVal)
 		}
+	case string:
+		// Try ISO and Synapse repr formats
+		return parseTimeLayouts(v)
 	}
 	return nil
 }
@@ -518,7 +3019,7 @@ func (d *Datasource) flattenObject(obj map[string]interface{}, prefix string) ma
 			// Arrays are serialized as JSON
 			jsonBytes, _ := json.Marshal(v)
 			result[newKey] = string(jsonBytes)
-		case float64, int, int64, bool:
+		case float64, int, int64, bool, json.Number:
 			// Preserve numeric and boolean types
 			result[newKey] = val
 		case nil:
@@ -531,11 +3032,20 @@ func (d *Datasource) flattenObject(obj map[string]interface{}, prefix string) ma
 	return result
 }
 
+// marshalJSON encodes a value for a JSON string column, indented when
+// Config.PrettyJSON is set
+func (d *Datasource) marshalJSON(val interface{}) ([]byte, error) {
+	if d.config.PrettyJSON {
+		return json.MarshalIndent(val, "", "  ")
+	}
+	return json.Marshal(val)
+}
+
 // valueToString converts a value to string, serializing nested structures as JSON
 func (d *Datasource) valueToString(val interface{}) string {
 	switch v := val.(type) {
 	case map[string]interface{}, []interface{}:
-		jsonBytes, err := json.Marshal(v)
+		jsonBytes, err := d.marshalJSON(v)
 		if err != nil {
 			return fmt.Sprintf("%v", val)
 		}
@@ -545,64 +3055,276 @@ func (d *Datasource) valueToString(val interface{}) string {
 	}
 }
 
-// Store query model for access in parsing functions
-var queryModel *QueryModel
-
 func (d *Datasource) queryStormCall(ctx context.Context, qm QueryModel, refID string) (data.Frames, error) {
-	// Store for access in parsing functions
-	d.queryModel = &qm
-	// Build request URL for Storm call
-	url := fmt.Sprintf("%s/api/v1/storm/call", d.settings.URL)
-
 	// Create request body with query and opts
-	reqBody, err := json.Marshal(map[string]interface{}{
-		"query": qm.StormQuery,
-		"opts":  qm.Opts,
-	})
+	reqBody, err := d.requestBody(qm.StormQuery, qm.Opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute request
+	resp, err := d.request(ctx, d.config.StormCallMethod, d.config.StormCallPath, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storm call failed with status: %d", resp.StatusCode)
+	}
+
+	// Parse response. A result list is parsed as it is read from the body
+	// unless the result needs to be reshaped as a whole
+	streamable := !qm.SingleJSONColumn && !qm.HeatmapMode && qm.MultiFrameKey == ""
+	response, frames, err := d.decodeCallResponse(resp.Body, qm, streamable, refID)
 	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	// Extract the actual result from the response. If no result field or
+	// status not ok, use the whole response
+	result, mesg, err := d.unwrapEnvelope(response)
 	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
+		return nil, err
+	}
+
+	if _, streamed := result.(streamedList); !streamed {
+		if qm.SingleJSONColumn {
+			frames, err = d.singleJSONFrame(result, refID)
+		} else if qm.HeatmapMode {
+			frames, err = d.heatmapFrame(result, refID)
+		} else if qm.MultiFrameKey != "" {
+			frames, err = d.multiFrames(result, qm, refID)
+		} else {
+			frames, err = d.parseStormCallResult(result, qm, refID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Surface any envelope message alongside the result
+	if mesg != "" && len(frames) > 0 {
+		frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     mesg,
+		})
+	}
+
+	return frames, nil
+}
+
+// unwrapEnvelope extracts the result and message from a storm/call response
+// envelope using the configured keys (default status, result and mesg). The
+// result is unwrapped when the status is "ok", or when no status is present
+// but a custom ResultKey is. Otherwise the whole response is the result.
+// An "err" status is returned as an error built from the message, code and
+// errinfo.
+func (d *Datasource) unwrapEnvelope(response map[string]interface{}) (interface{}, string, error) {
+	statusKey, resultKey, messageKey := d.envelopeKeys()
+	mesg, _ := response[messageKey].(string)
+
+	status, hasStatus := response[statusKey].(string)
+	if hasStatus && status == "err" {
+		return nil, "", envelopeError(response, mesg)
+	}
+	if hasStatus && status == "ok" {
+		if res, exists := response[resultKey]; exists {
+			return res, mesg, nil
+		}
+	}
+	if !hasStatus && d.config.ResultKey != "" {
+		if res, exists := response[resultKey]; exists {
+			return res, mesg, nil
+		}
+	}
+
+	return response, "", nil
+}
+
+// envelopeKeys returns the configured storm/call envelope status, result and
+// message keys, defaulting to status, result and mesg
+func (d *Datasource) envelopeKeys() (string, string, string) {
+	statusKey, resultKey, messageKey := "status", "result", "mesg"
+	if d.config.StatusKey != "" {
+		statusKey = d.config.StatusKey
+	}
+	if d.config.ResultKey != "" {
+		resultKey = d.config.ResultKey
+	}
+	if d.config.MessageKey != "" {
+		messageKey = d.config.MessageKey
+	}
+	return statusKey, resultKey, messageKey
+}
+
+// envelopeError formats a storm/call "err" envelope as an error, e.g.
+// "storm call error: BadArg: invalid argument"
+func envelopeError(response map[string]interface{}, mesg string) error {
+	if mesg == "" {
+		if info, ok := response["errinfo"].(map[string]interface{}); ok {
+			mesg, _ = info["mesg"].(string)
+		}
+	}
+	if mesg == "" {
+		mesg = "unknown error"
+	}
+	if code, ok := response["code"].(string); ok && code != "" {
+		return fmt.Errorf("storm call error: %s: %s", code, mesg)
+	}
+	return fmt.Errorf("storm call error: %s", mesg)
+}
+
+// multiFrames parses each {"name": ..., key: ...} envelope of a result list
+// as its own frame named after the envelope
+func (d *Datasource) multiFrames(result interface{}, qm QueryModel, refID string) (data.Frames, error) {
+	key := qm.MultiFrameKey
+	envelopes, ok := result.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("multi-frame results must be a list of envelopes, got %T", result)
+	}
+
+	var frames data.Frames
+	for i, item := range envelopes {
+		envelope, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("multi-frame result %d is not an object", i)
+		}
+		content, ok := envelope[key]
+		if !ok {
+			return nil, fmt.Errorf("multi-frame result %d has no %q key", i, key)
+		}
+
+		envelopeFrames, err := d.parseStormCallResult(content, qm, refID)
+		if err != nil {
+			return nil, fmt.Errorf("multi-frame result %d: %w", i, err)
+		}
+		name, _ := envelope["name"].(string)
+		if name == "" {
+			name = fmt.Sprintf("result %d", i)
+		}
+		for _, frame := range envelopeFrames {
+			frame.Name = name
+		}
+		frames = append(frames, envelopeFrames...)
+	}
+	return frames, nil
+}
+
+// heatmapFrame reshapes a nested {xbucket: {ybucket: count}} map into the
+// heatmap-rows layout. X buckets become a time field when every key parses as
+// a time, and y buckets are ordered numerically when every key is a number.
+// Missing cells count as zero.
+func (d *Datasource) heatmapFrame(result interface{}, refID string) (data.Frames, error) {
+	rows, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("heatmap mode requires a {xbucket: {ybucket: count}} result, got %T", result)
+	}
+
+	xKeys := make([]string, 0, len(rows))
+	yBuckets := make(map[string]bool)
+	for x, row := range rows {
+		cells, ok := row.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("heatmap mode requires a {ybucket: count} object for bucket %q, got %T", x, row)
+		}
+		xKeys = append(xKeys, x)
+		for y := range cells {
+			yBuckets[y] = true
+		}
+	}
+
+	yKeys := make([]string, 0, len(yBuckets))
+	for y := range yBuckets {
+		yKeys = append(yKeys, y)
+	}
+	sortBucketKeys(yKeys)
+
+	xTimes := make(map[string]time.Time, len(xKeys))
+	for _, x := range xKeys {
+		t := d.parseTimeValueFromString(x)
+		if t == nil {
+			xTimes = nil
+			break
+		}
+		xTimes[x] = *t
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	// Execute request
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("execute request: %w", err)
+	frame := data.NewFrame("heatmap")
+	frame.RefID = refID
+	if xTimes != nil {
+		sort.Slice(xKeys, func(i, j int) bool { return xTimes[xKeys[i]].Before(xTimes[xKeys[j]]) })
+		times := make([]time.Time, len(xKeys))
+		for i, x := range xKeys {
+			times[i] = xTimes[x]
+		}
+		frame.Fields = append(frame.Fields, data.NewField("time", nil, times))
+	} else {
+		sortBucketKeys(xKeys)
+		frame.Fields = append(frame.Fields, data.NewField("x", nil, xKeys))
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("storm call failed with status: %d", resp.StatusCode)
+	for _, y := range yKeys {
+		counts := make([]float64, len(xKeys))
+		for i, x := range xKeys {
+			cells := rows[x].(map[string]interface{})
+			if count, ok := numericValue(cells[y]); ok {
+				counts[i] = count
+			}
+		}
+		frame.Fields = append(frame.Fields, data.NewField(y, nil, counts))
 	}
 
-	// Parse response
-	var response map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
-	}
+	frame.SetMeta(&data.FrameMeta{Type: data.FrameType("heatmap-rows")})
+	return data.Frames{frame}, nil
+}
 
-	// Extract the actual result from the response
-	if status, ok := response["status"].(string); ok && status == "ok" {
-		if result, exists := response["result"]; exists {
-			return d.parseStormCallResult(result, refID)
+// sortBucketKeys sorts bucket keys numerically when all are numbers, and
+// lexically otherwise
+func sortBucketKeys(keys []string) {
+	nums := make(map[string]float64, len(keys))
+	for _, key := range keys {
+		num, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			sort.Strings(keys)
+			return
 		}
+		nums[key] = num
+	}
+	sort.Slice(keys, func(i, j int) bool { return nums[keys[i]] < nums[keys[j]] })
+}
+
+// singleJSONFrame returns the whole result marshaled into a single json cell
+func (d *Datasource) singleJSONFrame(result interface{}, refID string) (data.Frames, error) {
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("marshal result: %w", err)
 	}
 
-	// If no result field or status not ok, return the whole response
-	return d.parseStormCallResult(response, refID)
+	frame := data.NewFrame("storm_call")
+	frame.RefID = refID
+	frame.Fields = append(frame.Fields,
+		data.NewField("json", nil, []string{string(jsonBytes)}),
+	)
+
+	return data.Frames{frame}, nil
 }
 
-func (d *Datasource) parseStormCallResult(result interface{}, refID string) (data.Frames, error) {
+func (d *Datasource) parseStormCallResult(result interface{}, qm QueryModel, refID string) (data.Frames, error) {
 	frame := data.NewFrame("storm_call")
 	frame.RefID = refID
 
 	switch v := result.(type) {
 	case []interface{}:
+		v = d.filterRows(v, qm.postFilters)
+		if maxRows, global := d.rowCap(qm); maxRows > 0 && len(v) > maxRows {
+			frames, err := d.parseStormCallResult(v[:maxRows], qm, refID)
+			if err == nil && len(frames) > 0 {
+				frames[0].AppendNotices(rowLimitNotice(maxRows, len(v), global))
+			}
+			return frames, err
+		}
+
 		// Handle list of items
 		if len(v) == 0 {
 			// Empty list
@@ -617,11 +3339,11 @@ func (d *Datasource) parseStormCallResult(result interface{}, refID string) (dat
 		switch firstItem.(type) {
 		case map[string]interface{}:
 			// List of objects - create table with columns from object keys
-			return d.parseObjectList(v, refID)
+			return d.parseObjectList(v, qm, refID)
 		case []interface{}:
 			// Could be list of nodes in [[form, value], {props}] format
 			if isNodeList(v) {
-				return d.parseNodeList(v, refID)
+				return d.parseNodeList(v, qm, refID)
 			}
 			// Otherwise treat as list of lists
 			return d.parseListOfLists(v, refID)
@@ -656,82 +3378,10 @@ func (d *Datasource) parseStormCallResult(result interface{}, refID string) (dat
 		)
 		
 		// Add value field with appropriate type
-		switch valueType {
-		case "float":
-			floatValues := make([]*float64, len(values))
-			for i, val := range values {
-				if val != nil {
-					switch v := val.(type) {
-					case float64:
-						floatValues[i] = &v
-					case int:
-						f := float64(v)
-						floatValues[i] = &f
-					case int64:
-						f := float64(v)
-						floatValues[i] = &f
-					case string:
-						// Try to parse string as number
-						if numVal, err := strconv.ParseFloat(v, 64); err == nil {
-							floatValues[i] = &numVal
-						}
-					}
-				}
-			}
-			frame.Fields = append(frame.Fields,
-				data.NewField("value", nil, floatValues),
-			)
-		case "int":
-			intValues := make([]*int64, len(values))
-			for i, val := range values {
-				if val != nil {
-					switch v := val.(type) {
-					case float64:
-						intVal := int64(v)
-						intValues[i] = &intVal
-					case int:
-						intVal := int64(v)
-						intValues[i] = &intVal
-					case int64:
-						intValues[i] = &v
-					case string:
-						// Try to parse string as number
-						if numVal, err := strconv.ParseInt(v, 10, 64); err == nil {
-							intValues[i] = &numVal
-						}
-					}
-				}
-			}
-			frame.Fields = append(frame.Fields,
-				data.NewField("value", nil, intValues),
-			)
-		case "bool":
-			boolValues := make([]*bool, len(values))
-			for i, val := range values {
-				if val != nil {
-					if b, ok := val.(bool); ok {
-						boolValues[i] = &b
-					}
-				}
-			}
-			frame.Fields = append(frame.Fields,
-				data.NewField("value", nil, boolValues),
-			)
-		default:
-			// String field
-			stringValues := make([]string, len(values))
-			for i, val := range values {
-				if val != nil {
-					stringValues[i] = fmt.Sprintf("%v", val)
-				} else {
-					stringValues[i] = ""
-				}
-			}
-			frame.Fields = append(frame.Fields,
-				data.NewField("value", nil, stringValues),
-			)
-		}
-		
+		frame.Fields = append(frame.Fields,
+			d.newTypedField("value", values, valueType, qm.DistinguishNull),
+		)
+
 		return data.Frames{frame}, nil
 
 	default:
@@ -743,6 +3393,31 @@ func (d *Datasource) parseStormCallResult(result interface{}, refID string) (dat
 	}
 }
 
+// rowCap returns the rows kept from a storm/call result list, the smaller of
+// MaxRows and Config.GlobalMaxNodes, and whether the datasource limit is the
+// one that applies. It is 0 when neither is set.
+func (d *Datasource) rowCap(qm QueryModel) (int, bool) {
+	maxRows := qm.MaxRows
+	if limit := d.config.GlobalMaxNodes; limit > 0 && (maxRows <= 0 || maxRows > limit) {
+		return limit, true
+	}
+	return maxRows, false
+}
+
+// rowLimitNotice notes a storm/call result list cut to maxRows of total rows
+func rowLimitNotice(maxRows int, total int, global bool) data.Notice {
+	if global {
+		return data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("results limited to %d of %d rows by the datasource node limit", maxRows, total),
+		}
+	}
+	return data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("results limited to %d of %d rows", maxRows, total),
+	}
+}
+
 func isNodeList(items []interface{}) bool {
 	if len(items) == 0 {
 		return false
@@ -758,21 +3433,74 @@ func isNodeList(items []interface{}) bool {
 	return false
 }
 
-func (d *Datasource) parseNodeList(items []interface{}, refID string) (data.Frames, error) {
+// propString renders a property value for a string column, avoiding fmt for
+// the common string and number values
+func propString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// valueField builds the node value column from the formatted values, or from
+// the raw primary values when asNumber is set and all of them are numbers
+func valueField(values []string, rawValues []interface{}, asNumber bool) *data.Field {
+	if !asNumber || len(rawValues) == 0 {
+		return data.NewField("value", nil, values)
+	}
+
+	ints := make([]int64, len(rawValues))
+	floats := make([]float64, len(rawValues))
+	allInts := true
+	for i, raw := range rawValues {
+		switch v := raw.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				ints[i], floats[i] = n, float64(n)
+				continue
+			}
+			f, err := v.Float64()
+			if err != nil {
+				return data.NewField("value", nil, values)
+			}
+			floats[i] = f
+			allInts = false
+		case float64:
+			floats[i] = v
+			allInts = false
+		default:
+			return data.NewField("value", nil, values)
+		}
+	}
+	if allInts {
+		return data.NewField("value", nil, ints)
+	}
+	return data.NewField("value", nil, floats)
+}
+
+func (d *Datasource) parseNodeList(items []interface{}, qm QueryModel, refID string) (data.Frames, error) {
 	frame := data.NewFrame("storm_call")
 	frame.RefID = refID
 
-	var forms []string
-	var values []string
-	var idens []string
-	var tags []string
+	forms := make([]string, 0, len(items))
+	values := make([]string, 0, len(items))
+	rawValues := make([]interface{}, 0, len(items))
+	ndefs := make([]string, 0, len(items))
+	idens := make([]string, 0, len(items))
+	tags := make([]string, 0, len(items))
 
 	for _, item := range items {
 		if nodeData, ok := item.([]interface{}); ok && len(nodeData) >= 2 {
 			if nodeDef, ok := nodeData[0].([]interface{}); ok && len(nodeDef) >= 2 {
 				if form, ok := nodeDef[0].(string); ok {
+					nodeInfo, _ := nodeData[1].(map[string]interface{})
 					forms = append(forms, form)
-					values = append(values, fmt.Sprintf("%v", nodeDef[1]))
+					values = append(values, d.nodeValueString(form, nodeDef[1], nodeInfo))
+					rawValues = append(rawValues, nodeDef[1])
+					ndefs = append(ndefs, ndefString(form, nodeDef[1]))
 				}
 			}
 			if nodeProps, ok := nodeData[1].(map[string]interface{}); ok {
@@ -787,7 +3515,7 @@ func (d *Datasource) parseNodeList(items []interface{}, refID string) (data.Fram
 					for tag := range nodeTags {
 						tagList = append(tagList, tag)
 					}
-					tags = append(tags, strings.Join(tagList, ", "))
+					tags = append(tags, d.tagsString(tagList))
 				} else {
 					tags = append(tags, "")
 				}
@@ -797,118 +3525,136 @@ func (d *Datasource) parseNodeList(items []interface{}, refID string) (data.Fram
 
 	if len(forms) > 0 {
 		frame.Fields = append(frame.Fields,
-			data.NewField("form", nil, forms),
-			data.NewField("value", nil, values),
+			d.formFields(forms, qm.FormLabels)...,
+		)
+		frame.Fields = append(frame.Fields,
+			valueField(values, rawValues, qm.ValueAsNumber),
 			data.NewField("iden", nil, idens),
 			data.NewField("tags", nil, tags),
 		)
+		if qm.IncludeNdef {
+			frame.Fields = append(frame.Fields,
+				data.NewField("ndef", nil, ndefs),
+			)
+		}
 	}
 
 	return data.Frames{frame}, nil
 }
 
-func (d *Datasource) parseObjectList(items []interface{}, refID string) (data.Frames, error) {
-	frame := data.NewFrame("storm_call")
-	frame.RefID = refID
+func (d *Datasource) parseObjectList(items []interface{}, qm QueryModel, refID string) (data.Frames, error) {
+	cols := d.newObjectColumns(qm)
+	for _, item := range items {
+		if obj, ok := item.(map[string]interface{}); ok {
+			d.addObject(cols, obj)
+		}
+	}
+	return d.objectFrames(cols, qm, refID), nil
+}
 
-	if len(items) == 0 {
-		return data.Frames{frame}, nil
+// objectColumns accumulates storm/call result objects column by column, so
+// objects can be added one at a time as they are decoded
+type objectColumns struct {
+	flatten bool
+	rows    int
+	counts  map[string]int           // objects holding each key
+	values  map[string][]interface{} // values per key, nil where absent
+}
+
+// newObjectColumns starts an empty set of columns, flattening nested objects
+// when the query sets opts.flatten
+func (d *Datasource) newObjectColumns(qm QueryModel) *objectColumns {
+	shouldFlatten, _ := qm.Opts["flatten"].(bool)
+	return &objectColumns{
+		flatten: shouldFlatten,
+		counts:  make(map[string]int),
+		values:  make(map[string][]interface{}),
 	}
+}
 
-	// Check if we should flatten nested objects
-	shouldFlatten := false
-	if d.queryModel != nil && d.queryModel.Opts != nil {
-		if flatten, ok := d.queryModel.Opts["flatten"].(bool); ok {
-			shouldFlatten = flatten
+// addObject appends obj as a row, backfilling nulls for new keys and for
+// keys obj lacks
+func (d *Datasource) addObject(cols *objectColumns, obj map[string]interface{}) {
+	if cols.flatten {
+		obj = d.flattenObject(obj, "")
+	}
+	for key, val := range obj {
+		col, ok := cols.values[key]
+		if !ok {
+			col = make([]interface{}, cols.rows, cols.rows+1)
 		}
+		cols.values[key] = append(col, val)
+		cols.counts[key]++
 	}
-
-	// Get all unique keys from all objects
-	keySet := make(map[string]bool)
-	for _, item := range items {
-		if obj, ok := item.(map[string]interface{}); ok {
-			if shouldFlatten {
-				// Collect flattened keys
-				flattened := d.flattenObject(obj, "")
-				for k := range flattened {
-					keySet[k] = true
-				}
-			} else {
-				for k := range obj {
-					keySet[k] = true
-				}
-			}
+	cols.rows++
+	for key, col := range cols.values {
+		if len(col) < cols.rows {
+			cols.values[key] = append(col, nil)
 		}
 	}
+}
+
+// objectFrames builds the storm_call frame from object columns with type
+// detection
+func (d *Datasource) objectFrames(cols *objectColumns, qm QueryModel, refID string) data.Frames {
+	frame := data.NewFrame("storm_call")
+	frame.RefID = refID
+
+	if cols.rows == 0 {
+		return data.Frames{frame}
+	}
 
 	// Create sorted list of keys
-	keys := make([]string, 0, len(keySet))
-	for k := range keySet {
+	keys := make([]string, 0, len(cols.counts))
+	for k := range cols.counts {
 		keys = append(keys, k)
 	}
-	sort.Strings(keys)
 
-	// Create fields for each key - use interface{} to preserve types
-	fields := make(map[string][]interface{})
-	for _, key := range keys {
-		fields[key] = []interface{}{}
+	// Keep the most common keys when there are too many for columns
+	var extraKeys []string
+	if limit := d.config.MaxObjectKeys; limit > 0 && len(keys) > limit {
+		sort.Slice(keys, func(i, j int) bool {
+			if cols.counts[keys[i]] != cols.counts[keys[j]] {
+				return cols.counts[keys[i]] > cols.counts[keys[j]]
+			}
+			return keys[i] < keys[j]
+		})
+		extraKeys = keys[limit:]
+		keys = keys[:limit]
 	}
+	sort.Strings(keys)
 
-	// Populate field values
-	for _, item := range items {
-		if obj, ok := item.(map[string]interface{}); ok {
-			if shouldFlatten {
-				// Flatten the object preserving types
-				flattened := d.flattenObject(obj, "")
-				for _, key := range keys {
-					if val, exists := flattened[key]; exists {
-						fields[key] = append(fields[key], val)
-					} else {
-						fields[key] = append(fields[key], nil)
-					}
-				}
-			} else {
-				// Preserve types in non-flattened mode too
-				for _, key := range keys {
-					if val, exists := obj[key]; exists {
-						// Only convert nested structures to JSON, preserve primitive types
-						switch v := val.(type) {
-						case map[string]interface{}, []interface{}:
-							jsonStr := d.valueToString(v)
-							fields[key] = append(fields[key], jsonStr)
-						default:
-							fields[key] = append(fields[key], val)
-						}
-					} else {
-						fields[key] = append(fields[key], nil)
-					}
+	// Add fields to frame with type detection
+	for _, key := range keys {
+		values := cols.values[key]
+		if !cols.flatten {
+			// Only convert nested structures to JSON, preserve primitive types
+			for i, val := range values {
+				switch v := val.(type) {
+				case map[string]interface{}, []interface{}:
+					values[i] = d.valueToString(v)
 				}
 			}
 		}
-	}
 
-	// Add fields to frame with type detection
-	for _, key := range keys {
+		// Declared types take precedence over detection
+		if fieldType, ok := qm.FieldTypes[key]; ok {
+			frame.Fields = append(frame.Fields,
+				d.newTypedField(key, values, fieldType, qm.DistinguishNull),
+			)
+			continue
+		}
+
 		// Determine field type from values
-		fieldType := d.detectFieldType(fields[key])
-
-		// Check if this is a time field
-		lowerKey := strings.ToLower(key)
-		isTimeField := strings.Contains(lowerKey, "created") ||
-			strings.Contains(lowerKey, "seen") ||
-			strings.Contains(lowerKey, "time") ||
-			strings.Contains(lowerKey, "modified") ||
-			strings.Contains(lowerKey, "updated") ||
-			strings.Contains(lowerKey, "accessed") ||
-			strings.Contains(lowerKey, "published") ||
-			strings.Contains(lowerKey, "date") ||
-			strings.Contains(lowerKey, "timestamp")
-
-		if isTimeField && (fieldType == "float" || fieldType == "int") {
+		fieldType := d.detectFieldType(values)
+
+		isTime := isTimeField(key)
+
+		if isTime && (fieldType == "float" || fieldType == "int") {
 			// Try to parse numeric values as timestamps
-			timeValues := make([]*time.Time, len(fields[key]))
+			timeValues := make([]*time.Time, len(values))
 			hasTimeValues := false
-			for i, val := range fields[key] {
+			for i, val := range values {
 				if timeVal := d.parseTimeValue(val); timeVal != nil {
 					timeValues[i] = timeVal
 					hasTimeValues = true
@@ -923,84 +3669,151 @@ func (d *Datasource) parseObjectList(items []interface{}, refID string) (data.Fr
 		}
 
 		// Add field based on detected type
-		switch fieldType {
-		case "float":
-			floatValues := make([]*float64, len(fields[key]))
-			for i, val := range fields[key] {
-				if val != nil {
-					switch v := val.(type) {
-					case float64:
-						floatValues[i] = &v
-					case int:
-						f := float64(v)
-						floatValues[i] = &f
-					case int64:
-						f := float64(v)
-						floatValues[i] = &f
-					case string:
-						// Try to parse string as number
-						if numVal, err := strconv.ParseFloat(v, 64); err == nil {
-							floatValues[i] = &numVal
-						}
+		frame.Fields = append(frame.Fields,
+			d.newTypedField(key, values, fieldType, qm.DistinguishNull),
+		)
+	}
+
+	if len(extraKeys) > 0 {
+		frame.Fields = append(frame.Fields, d.extraKeysField(cols, extraKeys))
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("objects have %d keys; %d less common keys are collapsed into the _extra column",
+				len(keys)+len(extraKeys), len(extraKeys)),
+		})
+	}
+
+	return data.Frames{frame}
+}
+
+// extraKeysField builds the _extra column holding each object's overflow
+// keys as JSON, null for objects without any
+func (d *Datasource) extraKeysField(cols *objectColumns, extraKeys []string) *data.Field {
+	values := make([]*string, cols.rows)
+	for row := range values {
+		extra := make(map[string]interface{})
+		for _, key := range extraKeys {
+			if val := cols.values[key][row]; val != nil {
+				extra[key] = val
+			}
+		}
+		if len(extra) > 0 {
+			extraJSON := d.valueToString(extra)
+			values[row] = &extraJSON
+		}
+	}
+	return data.NewField("_extra", nil, values)
+}
+
+// newTypedField builds a field of the given type ("string", "int", "float",
+// "bool" or "time") from raw values. Values that cannot be converted are null.
+// String fields are nullable only when distinguishNull is set.
+func (d *Datasource) newTypedField(key string, values []interface{}, fieldType string, distinguishNull bool) *data.Field {
+	switch fieldType {
+	case "time":
+		timeValues := make([]*time.Time, len(values))
+		for i, val := range values {
+			if str, ok := val.(string); ok {
+				timeValues[i] = d.parseTimeValueFromString(str)
+			} else {
+				timeValues[i] = d.parseTimeValue(val)
+			}
+		}
+		return data.NewField(key, nil, timeValues)
+	case "float":
+		floatValues := make([]*float64, len(values))
+		for i, val := range values {
+			if val != nil {
+				switch v := val.(type) {
+				case float64:
+					floatValues[i] = &v
+				case json.Number:
+					if numVal, err := v.Float64(); err == nil {
+						floatValues[i] = &numVal
+					}
+				case int:
+					f := float64(v)
+					floatValues[i] = &f
+				case int64:
+					f := float64(v)
+					floatValues[i] = &f
+				case string:
+					// Try to parse string as number
+					if numVal, err := strconv.ParseFloat(v, 64); err == nil {
+						floatValues[i] = &numVal
 					}
 				}
 			}
-			frame.Fields = append(frame.Fields,
-				data.NewField(key, nil, floatValues),
-			)
-		case "int":
-			intValues := make([]*int64, len(fields[key]))
-			for i, val := range fields[key] {
-				if val != nil {
-					switch v := val.(type) {
-					case float64:
-						intVal := int64(v)
-						intValues[i] = &intVal
-					case int:
-						intVal := int64(v)
+		}
+		return data.NewField(key, nil, floatValues)
+	case "int":
+		intValues := make([]*int64, len(values))
+		for i, val := range values {
+			if val != nil {
+				switch v := val.(type) {
+				case float64:
+					intVal := int64(v)
+					intValues[i] = &intVal
+				case json.Number:
+					if numVal, err := v.Int64(); err == nil {
+						intValues[i] = &numVal
+					} else if floatVal, err := v.Float64(); err == nil {
+						intVal := int64(floatVal)
 						intValues[i] = &intVal
-					case int64:
-						intValues[i] = &v
-					case string:
-						// Try to parse string as number
-						if numVal, err := strconv.ParseInt(v, 10, 64); err == nil {
-							intValues[i] = &numVal
-						}
+					}
+				case int:
+					intVal := int64(v)
+					intValues[i] = &intVal
+				case int64:
+					intValues[i] = &v
+				case string:
+					// Try to parse string as number
+					if numVal, err := strconv.ParseInt(v, 10, 64); err == nil {
+						intValues[i] = &numVal
 					}
 				}
 			}
-			frame.Fields = append(frame.Fields,
-				data.NewField(key, nil, intValues),
-			)
-		case "bool":
-			boolValues := make([]*bool, len(fields[key]))
-			for i, val := range fields[key] {
-				if val != nil {
-					if b, ok := val.(bool); ok {
+		}
+		return data.NewField(key, nil, intValues)
+	case "bool":
+		boolValues := make([]*bool, len(values))
+		for i, val := range values {
+			if val != nil {
+				switch v := val.(type) {
+				case bool:
+					boolValues[i] = &v
+				case string:
+					if b, err := strconv.ParseBool(v); err == nil {
 						boolValues[i] = &b
 					}
 				}
 			}
-			frame.Fields = append(frame.Fields,
-				data.NewField(key, nil, boolValues),
-			)
-		default:
-			// String field
-			stringValues := make([]string, len(fields[key]))
-			for i, val := range fields[key] {
+		}
+		return data.NewField(key, nil, boolValues)
+	default:
+		if distinguishNull {
+			// Nullable string field so absent keys stay null
+			stringValues := make([]*string, len(values))
+			for i, val := range values {
 				if val != nil {
-					stringValues[i] = fmt.Sprintf("%v", val)
-				} else {
-					stringValues[i] = ""
+					strVal := fmt.Sprintf("%v", val)
+					stringValues[i] = &strVal
 				}
 			}
-			frame.Fields = append(frame.Fields,
-				data.NewField(key, nil, stringValues),
-			)
+			return data.NewField(key, nil, stringValues)
 		}
-	}
 
-	return data.Frames{frame}, nil
+		// String field
+		stringValues := make([]string, len(values))
+		for i, val := range values {
+			if val != nil {
+				stringValues[i] = fmt.Sprintf("%v", val)
+			} else {
+				stringValues[i] = ""
+			}
+		}
+		return data.NewField(key, nil, stringValues)
+	}
 }
 
 // detectFieldType detects the common type of values in a slice
@@ -1030,6 +3843,15 @@ func (d *Datasource) detectFieldType(values []interface{}) string {
 			}
 		case int, int64:
 			hasInt = true
+		case json.Number:
+			// Integers that overflow int64 stay strings to keep full precision
+			if _, err := v.Int64(); err == nil {
+				hasInt = true
+			} else if strings.ContainsAny(v.String(), ".eE") {
+				hasFloat = true
+			} else {
+				hasOther = true
+			}
 		case bool:
 			hasBool = true
 		case string:
@@ -1108,7 +3930,7 @@ func (d *Datasource) parseListOfLists(items []interface{}, refID string) (data.F
 	// Convert to JSON string representation for complex nested structures
 	values := make([]string, len(items))
 	for i, item := range items {
-		jsonBytes, _ := json.Marshal(item)
+		jsonBytes, _ := d.marshalJSON(item)
 		values[i] = string(jsonBytes)
 	}
 
@@ -1126,24 +3948,42 @@ func (d *Datasource) parseListOfLists(items []interface{}, refID string) (data.F
 func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	log.DefaultLogger.Info("CheckHealth called")
 
+	// Grafana does not mark requests from the test button, so they are
+	// served from the cache too; saving the settings creates a new instance
+	// with an empty cache
+	ttl := time.Duration(d.config.HealthCacheTTL) * time.Millisecond
+	if ttl > 0 {
+		d.healthMu.Lock()
+		cached, at := d.healthResult, d.healthAt
+		d.healthMu.Unlock()
+		if cached != nil && time.Since(at) < ttl {
+			return cached, nil
+		}
+	}
+
+	result, err := d.checkHealth(ctx)
+	if err == nil && ttl > 0 && result.Status == backend.HealthStatusOk {
+		d.healthMu.Lock()
+		d.healthResult, d.healthAt = result, time.Now()
+		d.healthMu.Unlock()
+	}
+	return result, err
+}
+
+// checkHealth probes the Cortex and, when configured, the default view
+func (d *Datasource) checkHealth(ctx context.Context) (*backend.CheckHealthResult, error) {
+	ctx, cancel := d.withTimeout(ctx, d.config.HealthTimeout)
+	defer cancel()
+
 	status := backend.HealthStatusOk
 	message := "Data source is working"
 
 	// Test connection to Cortex API using Storm endpoint
-	url := fmt.Sprintf("%s/api/v1/storm", d.settings.URL)
-	reqBody := []byte(`{"query": ""}`)
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	reqBody, err := d.requestBody(healthProbeQuery, nil)
 	if err != nil {
-		status = backend.HealthStatusError
-		message = fmt.Sprintf("Failed to create request: %v", err)
-		return &backend.CheckHealthResult{
-			Status:  status,
-			Message: message,
-		}, nil
+		return nil, err
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := d.httpClient.Do(httpReq)
+	resp, err := d.post(ctx, "/api/v1/storm", reqBody)
 	if err != nil {
 		status = backend.HealthStatusError
 		message = fmt.Sprintf("Failed to connect to Cortex: %v", err)
@@ -1154,9 +3994,38 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		// Report whether a key was configured, without revealing it
+		keyConfigured := "no"
+		if d.httpClient.apiKey != "" {
+			keyConfigured = "yes"
+		}
+		mesg := fmt.Sprintf("status %d", resp.StatusCode)
+		var errBody struct {
+			Mesg string `json:"mesg"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errBody); err == nil && errBody.Mesg != "" {
+			mesg = errBody.Mesg
+		}
+		status = backend.HealthStatusError
+		message = fmt.Sprintf("authentication failed: %s (key configured: %s)", mesg, keyConfigured)
+	} else if resp.StatusCode == http.StatusBadRequest {
+		status = backend.HealthStatusError
+		message = fmt.Sprintf("probe query rejected: Cortex returned status %d for %q", resp.StatusCode, healthProbeQuery)
+	} else if resp.StatusCode != http.StatusOK {
 		status = backend.HealthStatusError
 		message = fmt.Sprintf("Cortex returned status: %d", resp.StatusCode)
+	} else if err := stormStreamError(resp.Body); err != nil {
+		status = backend.HealthStatusError
+		message = fmt.Sprintf("probe query failed: %v", err)
+	} else if view, err := d.defaultView(ctx); err != nil {
+		status = backend.HealthStatusError
+		message = fmt.Sprintf("Default view %s could not be resolved: %v", d.config.DefaultViewName, err)
+	} else if view != "" {
+		if err := d.checkView(ctx, view); err != nil {
+			status = backend.HealthStatusError
+			message = fmt.Sprintf("Default view %s is not accessible: %v", view, err)
+		}
 	}
 
 	return &backend.CheckHealthResult{
@@ -1164,3 +4033,51 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 		Message: message,
 	}, nil
 }
+
+// checkView runs an empty Storm query scoped to view and returns any error
+// reported in the message stream
+func (d *Datasource) checkView(ctx context.Context, view string) error {
+	reqBody, err := d.requestBody(healthProbeQuery, map[string]interface{}{"view": view})
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.post(ctx, "/api/v1/storm", reqBody)
+	if err != nil {
+		return fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: %d", resp.StatusCode)
+	}
+
+	return stormStreamError(resp.Body)
+}
+
+// healthProbeQuery is a valid no-op Storm query used to probe the Cortex
+const healthProbeQuery = "$probe = $lib.null"
+
+// stormStreamError reads a Storm message stream until fini and returns the
+// first err message as an error
+func stormStreamError(body io.Reader) error {
+	decoder := json.NewDecoder(body)
+	for {
+		var msg StormMessage
+		if err := decoder.Decode(&msg); err != nil {
+			return nil
+		}
+		if len(msg) < 2 {
+			continue
+		}
+		switch msg[0] {
+		case "err":
+			if errData, ok := msg[1].([]interface{}); ok && len(errData) >= 2 {
+				return fmt.Errorf("%v: %v", errData[0], errData[1])
+			}
+			return fmt.Errorf("%v", msg[1])
+		case "fini":
+			return nil
+		}
+	}
+}
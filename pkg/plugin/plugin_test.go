@@ -0,0 +1,393 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestTypedVarValue(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want interface{}
+	}{
+		{"42", int64(42)},
+		{"-3", int64(-3)},
+		{"0", int64(0)},
+		{"0.5", 0.5},
+		{"1.5e3", 1500.0},
+		{"true", true},
+		{"false", false},
+		{"007", "007"},
+		{"-007", "-007"},
+		{"00.5", "00.5"},
+		{"NaN", "NaN"},
+		{"Inf", "Inf"},
+		{"-Infinity", "-Infinity"},
+		{"1e400", "1e400"},
+		{"cno.infra", "cno.infra"},
+	} {
+		if got := typedVarValue(tc.in); got != tc.want {
+			t.Errorf("typedVarValue(%q) = %#v, want %#v", tc.in, got, tc.want)
+		}
+	}
+}
+
+// queryCortex runs query through QueryData against a stub Cortex answering
+// every request with response. It returns the frames of the query and the
+// body of the last request.
+func queryCortex(t *testing.T, config string, query string, response string) (data.Frames, []byte) {
+	t.Helper()
+	var reqBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ = io.ReadAll(r.Body)
+		io.WriteString(w, response)
+	}))
+
+	inst, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		URL:      srv.URL,
+		JSONData: []byte(config),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := inst.(*Datasource).QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{RefID: "A", JSON: []byte(query)}},
+	})
+	srv.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Responses["A"]; res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	return resp.Responses["A"].Frames, reqBody
+}
+
+// queryNodes runs a storm query answered by the given node messages and
+// returns its primary frame
+func queryNodes(t *testing.T, config string, nodes ...string) *data.Frame {
+	t.Helper()
+	var stream string
+	for _, node := range nodes {
+		stream += `["node", ` + node + "]\n"
+	}
+	frames, _ := queryCortex(t, config, `{"stormQuery": "test"}`, stream+`["fini", {}]`+"\n")
+	return frames[0]
+}
+
+// fieldValue returns the value of the named field at row, nil when null
+func fieldValue(t *testing.T, frame *data.Frame, name string, row int) interface{} {
+	t.Helper()
+	field, _ := frame.FieldByName(name)
+	if field == nil {
+		t.Fatalf("frame has no %s field", name)
+	}
+	val, _ := field.ConcreteAt(row)
+	return val
+}
+
+func TestNestedReprColumns(t *testing.T) {
+	frame := queryNodes(t, `{}`,
+		`[["ou:campaign", "2f8a"], {"iden": "a1", "props": {"period": [1577836800000, 1609459200000]}, "reprs": {"period": ["2020/01/01", "2021/01/01"]}}]`,
+	)
+
+	if got := fieldValue(t, frame, "period_repr", 0); got != `["2020/01/01","2021/01/01"]` {
+		t.Errorf("period_repr = %v, want the JSON encoded ival repr", got)
+	}
+}
+
+func TestNullPrimaryGuidNode(t *testing.T) {
+	const node = `[["ps:contact", null], {"iden": "b2", "props": {"name": "visi"}}]`
+
+	for _, nullValue := range []string{"", "(null)"} {
+		config := `{"nullValue": "` + nullValue + `"}`
+
+		frame := queryNodes(t, config, node)
+		if got := fieldValue(t, frame, "value", 0); got != nullValue {
+			t.Errorf("stream value = %q, want %q", got, nullValue)
+		}
+
+		frames, _ := queryCortex(t, config, `{"stormQuery": "test", "useCall": true}`, `{"status": "ok", "result": [`+node+`]}`)
+		if got := fieldValue(t, frames[0], "value", 0); got != nullValue {
+			t.Errorf("node list value = %q, want %q", got, nullValue)
+		}
+	}
+}
+
+func TestCompFormNodeValue(t *testing.T) {
+	frame := queryNodes(t, `{}`,
+		`[["inet:dns:a", ["vertex.link", 167772160]], {"iden": "c3", "repr": "(vertex.link, 10.0.0.0)"}]`,
+		`[["inet:dns:a", ["vertex.link", 167772161]], {"iden": "c4"}]`,
+	)
+
+	if got := fieldValue(t, frame, "value", 0); got != "(vertex.link, 10.0.0.0)" {
+		t.Errorf("value with repr = %q, want the comp repr", got)
+	}
+	if got := fieldValue(t, frame, "value", 1); got != `["vertex.link",167772161]` {
+		t.Errorf("value without repr = %q, want the JSON encoded comp", got)
+	}
+}
+
+func TestIntVarsStayIntegers(t *testing.T) {
+	vars := map[string]interface{}{"limit": 5.0, "ratio": 0.5, "ports": []interface{}{80.0, 443.0}}
+	for k, v := range vars {
+		vars[k] = normalizeVarNumbers(v)
+	}
+	if _, ok := vars["limit"].(int64); !ok {
+		t.Errorf("limit is %T, want int64", vars["limit"])
+	}
+	if _, ok := vars["ratio"].(float64); !ok {
+		t.Errorf("ratio is %T, want float64", vars["ratio"])
+	}
+	if port, ok := vars["ports"].([]interface{})[0].(int64); !ok || port != 80 {
+		t.Errorf("ports[0] = %#v, want int64 80", vars["ports"].([]interface{})[0])
+	}
+
+	_, body := queryCortex(t, `{}`,
+		`{"stormQuery": "inet:ipv4 | limit $limit", "opts": {"vars": {"limit": 5, "since": 1700000000000, "ratio": 0.5, "ports": [80, 443]}}}`,
+		`["fini", {}]`+"\n",
+	)
+	for _, want := range []string{`"limit":5,`, `"since":1700000000000`, `"ratio":0.5`, `"ports":[80,443]`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("request body %s does not contain %s", body, want)
+		}
+	}
+}
+
+func TestParseReprTimes(t *testing.T) {
+	d := &Datasource{}
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"2023/05/01 13:00:00.000", time.Date(2023, 5, 1, 13, 0, 0, 0, time.UTC)},
+		{"2023/05/01 13:00:00", time.Date(2023, 5, 1, 13, 0, 0, 0, time.UTC)},
+		{"2023/05/01", time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"2023-05-01T13:00:00Z", time.Date(2023, 5, 1, 13, 0, 0, 0, time.UTC)},
+		{"2023-05-01 13:00:00", time.Date(2023, 5, 1, 13, 0, 0, 0, time.UTC)},
+	} {
+		for name, got := range map[string]*time.Time{
+			"parseTimeValue":           d.parseTimeValue(tc.in),
+			"parseTimeValueFromString": d.parseTimeValueFromString(tc.in),
+		} {
+			if got == nil || !got.Equal(tc.want) {
+				t.Errorf("%s(%q) = %v, want %v", name, tc.in, got, tc.want)
+			}
+		}
+	}
+
+	for _, in := range []string{"", "vertex.link", "2023/13/45"} {
+		if got := d.parseTimeValue(in); got != nil {
+			t.Errorf("parseTimeValue(%q) = %v, want nil", in, got)
+		}
+		if got := d.parseTimeValueFromString(in); got != nil {
+			t.Errorf("parseTimeValueFromString(%q) = %v, want nil", in, got)
+		}
+	}
+}
+
+func TestEpochTimeBoundaries(t *testing.T) {
+	auto := &Datasource{}
+	for _, tc := range []struct {
+		in   float64
+		want time.Time // zero when not a time
+	}{
+		{1e9, time.Time{}},
+		{1e9 + 1, time.Unix(1e9+1, 0)},
+		{1600000000, time.Unix(1600000000, 0)},
+		{1e11 - 1, time.Unix(1e11-1, 0)},
+		{1e11, time.UnixMilli(1e11)},
+		{1600000000000, time.UnixMilli(1600000000000)},
+		{1e14 - 1, time.UnixMilli(1e14 - 1)},
+		{1e14, time.UnixMicro(1e14)},
+		{1600000000000000, time.UnixMicro(1600000000000000)},
+	} {
+		got := auto.epochTime(tc.in)
+		switch {
+		case tc.want.IsZero() && got != nil:
+			t.Errorf("epochTime(%v) = %v, want nil", tc.in, got)
+		case !tc.want.IsZero() && (got == nil || !got.Equal(tc.want)):
+			t.Errorf("epochTime(%v) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+
+	// An explicit unit applies at any magnitude
+	for _, tc := range []struct {
+		unit string
+		in   float64
+		want time.Time
+	}{
+		{"s", 1600000000000, time.Unix(1600000000000, 0)},
+		{"ms", 1600000000000, time.UnixMilli(1600000000000)},
+		{"us", 1600000000000, time.UnixMicro(1600000000000)},
+		{"s", 1e9, time.Unix(1e9, 0)},
+		{"ms", 5e8, time.UnixMilli(5e8)},
+		{"us", 1000, time.UnixMicro(1000)},
+	} {
+		d := &Datasource{config: Config{TimeUnit: tc.unit}}
+		if got := d.epochTime(tc.in); got == nil || !got.Equal(tc.want) {
+			t.Errorf("epochTime(%v) with TimeUnit %s = %v, want %v", tc.in, tc.unit, got, tc.want)
+		}
+	}
+}
+
+func TestBoolAndNullPrimaryNodes(t *testing.T) {
+	const config = `{"nullValue": "-"}`
+	nodes := []string{
+		`[["test:bool", true], {"iden": "d1"}]`,
+		`[["test:bool", false], {"iden": "d2"}]`,
+		`[["test:guid", null], {"iden": "d3"}]`,
+	}
+	want := []string{"true", "false", "-"}
+
+	frame := queryNodes(t, config, nodes...)
+	frames, _ := queryCortex(t, config, `{"stormQuery": "test", "useCall": true}`,
+		`{"status": "ok", "result": [`+strings.Join(nodes, ",")+`]}`)
+	for i := range nodes {
+		if got := fieldValue(t, frame, "value", i); got != want[i] {
+			t.Errorf("stream value %d = %q, want %q", i, got, want[i])
+		}
+		if got := fieldValue(t, frames[0], "value", i); got != want[i] {
+			t.Errorf("node list value %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestDurationProps(t *testing.T) {
+	frame := queryNodes(t, `{}`,
+		`[["test:dur", 1], {"iden": "e1", "props": {"duration": 3600000, "ttl:duration": "1D"}}]`,
+		`[["test:dur", 2], {"iden": "e2", "props": {"duration": 1500, "ttl:duration": "2h"}}]`,
+	)
+
+	if field, _ := frame.FieldByName("duration"); field == nil || !field.Type().Numeric() {
+		t.Errorf("numeric duration should get a numeric field, got %v", field)
+	}
+	if got := fieldValue(t, frame, "ttl:duration", 0); got != "1D" {
+		t.Errorf("non-numeric duration = %v, want it kept as the string 1D", got)
+	}
+}
+
+// stormStreamFixture builds a Storm message stream of n inet:ipv4 nodes
+// followed by fini
+func stormStreamFixture(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`["init", {"tick": 1600000000000}]` + "\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `["node", [["inet:ipv4", %d], {"iden": "%064x", "tags": {"cno": [null, null], "cno.infra": [null, null]}, "props": {"asn": %d, "loc": "us", ".created": 1600000000000}}]]`+"\n",
+			i, i, i%100)
+	}
+	buf.WriteString(`["fini", {"tock": 1600000001000, "count": ` + fmt.Sprint(n) + `}]` + "\n")
+	return buf.Bytes()
+}
+
+func TestParseStormStreamChunkedReads(t *testing.T) {
+	d := &Datasource{}
+	stream := stormStreamFixture(50)
+
+	frames, err := d.parseStormStream(&chunkedReader{data: stream}, QueryModel{}, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := frames[0].Rows(); rows != 50 {
+		t.Fatalf("decoded %d nodes from 1-3 byte reads, want 50", rows)
+	}
+	if frames[0].Meta != nil && len(frames[0].Meta.Notices) > 0 {
+		t.Errorf("unexpected notices: %v", frames[0].Meta.Notices)
+	}
+}
+
+func TestParseStormStreamCutOff(t *testing.T) {
+	d := &Datasource{}
+	stream := stormStreamFixture(10)
+	// End the stream inside the last node message
+	cut := bytes.LastIndex(stream, []byte(`["node"`)) + 20
+
+	frames, err := d.parseStormStream(&chunkedReader{data: stream[:cut]}, QueryModel{}, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := frames[0].Rows(); rows != 9 {
+		t.Errorf("decoded %d nodes, want the 9 complete ones", rows)
+	}
+	if frames[0].Meta == nil || len(frames[0].Meta.Notices) != 1 || frames[0].Meta.Notices[0].Severity != data.NoticeSeverityWarning {
+		t.Fatalf("want a warning notice for the cut off stream, got %v", frames[0].Meta)
+	}
+}
+
+func BenchmarkParseStormStream(b *testing.B) {
+	stream := stormStreamFixture(100000)
+	d := &Datasource{}
+	for _, bc := range []struct {
+		name string
+		qm   QueryModel
+	}{
+		{"strings", QueryModel{}},
+		{"distinguishNull", QueryModel{DistinguishNull: true}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := d.parseStormStream(bytes.NewReader(stream), bc.qm, "A"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPropColumns compares building the nullable string prop columns of
+// 100k nodes with the previous loop, formatting every value with fmt into its
+// own allocation, against propString writing into one shared backing slice
+func BenchmarkPropColumns(b *testing.B) {
+	props := make([]map[string]interface{}, 100000)
+	for i := range props {
+		props[i] = map[string]interface{}{"asn": json.Number(fmt.Sprint(i % 100)), "loc": "us"}
+	}
+	keys := []string{"asn", "loc"}
+
+	b.Run("sprintf", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			for _, key := range keys {
+				values := make([]*string, len(props))
+				for i, p := range props {
+					if val, exists := p[key]; exists && val != nil {
+						strVal := fmt.Sprintf("%v", val)
+						values[i] = &strVal
+					}
+				}
+				benchColumn = values
+			}
+		}
+	})
+	b.Run("propString", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			for _, key := range keys {
+				strValues := make([]string, len(props))
+				values := make([]*string, len(props))
+				for i := range props {
+					if val, exists := props[i][key]; exists && val != nil {
+						strValues[i] = propString(val)
+						values[i] = &strValues[i]
+					}
+				}
+				benchColumn = values
+			}
+		}
+	})
+}
+
+// benchColumn keeps benchmarked columns live
+var benchColumn []*string
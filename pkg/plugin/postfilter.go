@@ -0,0 +1,184 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PostFilter keeps only result rows whose field compares to value with op:
+// eq, neq, gt, lt, contains or regex
+type PostFilter struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// postFilter is a PostFilter with its regex compiled
+type postFilter struct {
+	PostFilter
+	pattern *regexp.Regexp
+}
+
+// compilePostFilters validates filters and compiles their regexes
+func compilePostFilters(filters []PostFilter) ([]postFilter, error) {
+	compiled := make([]postFilter, 0, len(filters))
+	for i, filter := range filters {
+		pf := postFilter{PostFilter: filter}
+		switch filter.Op {
+		case "eq", "neq", "gt", "lt", "contains":
+		case "regex":
+			pattern, err := regexp.Compile(filter.Value)
+			if err != nil {
+				return nil, fmt.Errorf("post filter %d: %w", i, err)
+			}
+			pf.pattern = pattern
+		default:
+			return nil, fmt.Errorf("post filter %d: unknown op %q", i, filter.Op)
+		}
+		if filter.Field == "" {
+			return nil, fmt.Errorf("post filter %d: field is required", i)
+		}
+		compiled = append(compiled, pf)
+	}
+	return compiled, nil
+}
+
+// matchPostFilters reports whether a row passes every filter, looking up
+// fields with get. Rows lacking a field only pass neq filters on it.
+func (d *Datasource) matchPostFilters(filters []postFilter, get func(field string) (interface{}, bool)) bool {
+	for _, filter := range filters {
+		val, ok := get(filter.Field)
+		if !ok || val == nil {
+			if filter.Op != "neq" {
+				return false
+			}
+			continue
+		}
+		if !d.matchPostFilter(filter, val) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPostFilter compares val with the filter value, numerically when both
+// are numbers. A list such as tags matches when any element does, and
+// matches neq when no element is equal.
+func (d *Datasource) matchPostFilter(filter postFilter, val interface{}) bool {
+	if list, ok := val.([]string); ok {
+		if filter.Op == "neq" {
+			for _, item := range list {
+				if item == filter.Value {
+					return false
+				}
+			}
+			return true
+		}
+		for _, item := range list {
+			if d.matchPostFilter(filter, item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	str := d.valueToString(val)
+	num, isNum := numericValue(val)
+	want, wantNum := numericValue(filter.Value)
+	numeric := isNum && wantNum
+
+	switch filter.Op {
+	case "eq":
+		if numeric {
+			return num == want
+		}
+		return str == filter.Value
+	case "neq":
+		if numeric {
+			return num != want
+		}
+		return str != filter.Value
+	case "gt":
+		if numeric {
+			return num > want
+		}
+		return str > filter.Value
+	case "lt":
+		if numeric {
+			return num < want
+		}
+		return str < filter.Value
+	case "contains":
+		return strings.Contains(str, filter.Value)
+	case "regex":
+		return filter.pattern.MatchString(str)
+	}
+	return false
+}
+
+// filterRows applies post filters to a storm/call result list
+func (d *Datasource) filterRows(items []interface{}, filters []postFilter) []interface{} {
+	if len(filters) == 0 {
+		return items
+	}
+	kept := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if d.rowMatches(item, filters) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// rowMatches reports whether a storm/call result item passes the post
+// filters. Objects are matched by key, and [[form, value], {info}]
+// nodes by form, value, iden, tags or property. Primitives and other lists
+// render as a value column and are matched as the value field.
+func (d *Datasource) rowMatches(item interface{}, filters []postFilter) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	switch row := item.(type) {
+	case map[string]interface{}:
+		return d.matchPostFilters(filters, func(field string) (interface{}, bool) {
+			val, ok := row[field]
+			return val, ok
+		})
+	case []interface{}:
+		if !isNodeList([]interface{}{row}) {
+			return d.matchPostFilters(filters, valueRow(row))
+		}
+		nodeDef := row[0].([]interface{})
+		info, _ := row[1].(map[string]interface{})
+		return d.matchPostFilters(filters, func(field string) (interface{}, bool) {
+			switch field {
+			case "form":
+				return nodeDef[0], true
+			case "value":
+				return nodeDef[1], true
+			case "iden":
+				val, ok := info["iden"]
+				return val, ok
+			case "tags":
+				tags, _ := info["tags"].(map[string]interface{})
+				names := make([]string, 0, len(tags))
+				for tag := range tags {
+					names = append(names, tag)
+				}
+				return names, true
+			}
+			props, _ := info["props"].(map[string]interface{})
+			val, ok := props[field]
+			return val, ok
+		})
+	}
+	return d.matchPostFilters(filters, valueRow(item))
+}
+
+// valueRow looks up the fields of an item rendered as a lone value column
+func valueRow(item interface{}) func(field string) (interface{}, bool) {
+	return func(field string) (interface{}, bool) {
+		return item, field == "value"
+	}
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// mustCompile compiles filters, failing the test on error
+func mustCompile(t *testing.T, filters ...PostFilter) []postFilter {
+	t.Helper()
+	compiled, err := compilePostFilters(filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compiled
+}
+
+func TestFilterRowsValueLists(t *testing.T) {
+	d := &Datasource{}
+	filters := mustCompile(t, PostFilter{Field: "value", Op: "gt", Value: "2"})
+	if kept := d.filterRows([]interface{}{"1", "3", nil, "5"}, filters); len(kept) != 2 || kept[0] != "3" || kept[1] != "5" {
+		t.Errorf("primitives kept %v, want [3 5]", kept)
+	}
+
+	filters = mustCompile(t, PostFilter{Field: "value", Op: "contains", Value: "vertex"})
+	lists := []interface{}{
+		[]interface{}{"vertex.link", "a"},
+		[]interface{}{"woot.com", "b"},
+	}
+	if kept := d.filterRows(lists, filters); len(kept) != 1 {
+		t.Errorf("lists of lists kept %v, want only the vertex.link row", kept)
+	}
+
+	filters = mustCompile(t, PostFilter{Field: "name", Op: "eq", Value: "x"})
+	if kept := d.filterRows([]interface{}{"x", "y"}, filters); len(kept) != 0 {
+		t.Errorf("primitives have no name field, kept %v", kept)
+	}
+}
+
+func TestPostFilterDropsEdgesOfFilteredNodes(t *testing.T) {
+	d := &Datasource{}
+	qm := QueryModel{WithEdges: true, postFilters: mustCompile(t, PostFilter{Field: "form", Op: "eq", Value: "inet:fqdn"})}
+
+	stream := `["node", [["inet:fqdn", "vertex.link"], {"iden": "f1", "path": {"edges": [["f2", {"type": "prop", "prop": "zone"}], ["f9", {"type": "edge", "verb": "refs"}]]}}]]
+["node", [["inet:ipv4", 1], {"iden": "f2", "path": {"edges": [["f1", {"type": "edge", "verb": "seen"}]]}}]]
+`
+	parsed, err := d.parseStormStream(strings.NewReader(stream), qm, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var edges *data.Frame
+	for _, frame := range parsed {
+		if frame.Name == "edges" {
+			edges = frame
+		}
+	}
+	if edges == nil {
+		t.Fatal("no edges frame")
+	}
+	if rows := edges.Rows(); rows != 1 {
+		t.Fatalf("edges frame has %d rows, want only f1 -> f9", rows)
+	}
+	if target := fieldValue(t, edges, "target", 0); target != "f9" {
+		t.Errorf("kept edge target = %v, want f9", target)
+	}
+}
+
+func TestPostFilterBeforeMaxNodes(t *testing.T) {
+	d := &Datasource{}
+	qm := QueryModel{MaxNodes: 2, postFilters: mustCompile(t, PostFilter{Field: "form", Op: "eq", Value: "inet:fqdn"})}
+
+	stream := `["node", [["inet:ipv4", 1], {"iden": "g1"}]]
+["node", [["inet:ipv4", 2], {"iden": "g2"}]]
+["node", [["inet:fqdn", "vertex.link"], {"iden": "g3"}]]
+["node", [["inet:fqdn", "woot.com"], {"iden": "g4"}]]
+["node", [["inet:fqdn", "example.com"], {"iden": "g5"}]]
+`
+	frames, err := d.parseStormStream(strings.NewReader(stream), qm, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := frames[0].Rows(); rows != 2 {
+		t.Fatalf("got %d rows, want the first 2 fqdn nodes", rows)
+	}
+	if iden := fieldValue(t, frames[0], "iden", 1); iden != "g4" {
+		t.Errorf("second row iden = %v, want g4", iden)
+	}
+	if frames[0].Meta == nil || len(frames[0].Meta.Notices) != 1 {
+		t.Errorf("want a node limit notice, got %v", frames[0].Meta)
+	}
+}
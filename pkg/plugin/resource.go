@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// newResourceHandler registers the datasource resource routes
+func (d *Datasource) newResourceHandler() backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", d.handleFeed)
+	mux.HandleFunc("/model/form/", d.handleModelForm)
+	mux.HandleFunc("/model/refresh", d.handleModelRefresh)
+	return httpadapter.New(mux)
+}
+
+// writeResourceError writes a JSON error body with the given status code
+func writeResourceError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if encErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encErr != nil {
+		log.DefaultLogger.Warn("Error writing resource response", "error", encErr)
+	}
+}
+
+// handleFeed forwards feed data to the Cortex feed endpoint. The request body
+// is passed through as-is, e.g. {"name": "syn.nodes", "items": [...]}.
+func (d *Datasource) handleFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResourceError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if !d.config.AllowFeed {
+		writeResourceError(w, http.StatusForbidden, fmt.Errorf("feed is disabled for this datasource"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeResourceError(w, http.StatusBadRequest, fmt.Errorf("read request: %w", err))
+		return
+	}
+
+	var feed map[string]interface{}
+	if err := json.Unmarshal(body, &feed); err != nil {
+		writeResourceError(w, http.StatusBadRequest, fmt.Errorf("unmarshal feed: %w", err))
+		return
+	}
+	if _, ok := feed["items"].([]interface{}); !ok {
+		writeResourceError(w, http.StatusBadRequest, fmt.Errorf("feed items are required"))
+		return
+	}
+
+	ctx, cancel := d.withTimeout(r.Context(), d.config.ResourceTimeout)
+	defer cancel()
+
+	resp, err := d.post(ctx, "/api/v1/feed", body)
+	if err != nil {
+		writeResourceError(w, http.StatusBadGateway, fmt.Errorf("execute request: %w", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	// Relay the Cortex response to the caller
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.DefaultLogger.Warn("Error relaying feed response", "error", err)
+	}
+}
+
+// handleModelForm returns the docs, properties and property types of the
+// form named by the path, e.g. GET /model/form/inet:ipv4
+func (d *Datasource) handleModelForm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeResourceError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/model/form/")
+	if name == "" {
+		writeResourceError(w, http.StatusBadRequest, fmt.Errorf("form name is required"))
+		return
+	}
+
+	ctx, cancel := d.withTimeout(r.Context(), d.config.ResourceTimeout)
+	defer cancel()
+
+	model, err := d.cortexModel(ctx, name)
+	if err != nil {
+		writeResourceError(w, http.StatusBadGateway, fmt.Errorf("load model: %w", err))
+		return
+	}
+
+	form, ok := model.Forms[name]
+	if !ok {
+		writeResourceError(w, http.StatusNotFound, fmt.Errorf("unknown form %q", name))
+		return
+	}
+
+	props := make([]modelProp, 0, len(form.Props))
+	for _, prop := range form.Props {
+		props = append(props, prop)
+	}
+	sort.Slice(props, func(i, j int) bool { return props[i].Name < props[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":  form.Name,
+		"type":  form.Type,
+		"doc":   form.Doc,
+		"props": props,
+	}); err != nil {
+		log.DefaultLogger.Warn("Error writing resource response", "error", err)
+	}
+}
+
+// handleModelRefresh clears the cached model defs so they are refetched, e.g.
+// after a package adds forms to the Cortex
+func (d *Datasource) handleModelRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResourceError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	d.invalidateModel()
+	w.WriteHeader(http.StatusNoContent)
+}
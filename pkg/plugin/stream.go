@@ -0,0 +1,299 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+)
+
+// defaultBatchSize is the number of nodes per incremental frame
+const defaultBatchSize = 500
+
+// streamPathPrefix prefixes the Grafana Live paths of incremental queries
+const streamPathPrefix = "storm/"
+
+// streamSubscribeTTL is how long an incremental query waits for a Live
+// subscriber before it is dropped
+const streamSubscribeTTL = 10 * time.Minute
+
+// incrementalFrames registers qm for streaming and returns an empty frame
+// pointing Grafana at the Live channel that will carry the results. Queries
+// not subscribed to within streamSubscribeTTL are dropped.
+func (d *Datasource) incrementalFrames(pCtx backend.PluginContext, qm QueryModel, refID string) data.Frames {
+	key, _ := json.Marshal(struct {
+		RefID string
+		Query string
+		Opts  map[string]interface{}
+	}{refID, qm.StormQuery, qm.Opts})
+	sum := sha256.Sum256(key)
+	path := streamPathPrefix + hex.EncodeToString(sum[:16])
+
+	now := time.Now()
+	d.streams.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(streamQuery).at) > streamSubscribeTTL {
+			d.streams.Delete(key)
+		}
+		return true
+	})
+	d.streams.Store(path, streamQuery{qm: qm, refID: refID, at: now})
+
+	uid := ""
+	if pCtx.DataSourceInstanceSettings != nil {
+		uid = pCtx.DataSourceInstanceSettings.UID
+	}
+	channel := live.Channel{
+		Scope:     live.ScopeDatasource,
+		Namespace: uid,
+		Path:      path,
+	}
+
+	frame := data.NewFrame("storm")
+	frame.RefID = refID
+	frame.SetMeta(&data.FrameMeta{Channel: channel.String()})
+	return data.Frames{frame}
+}
+
+// streamQuery is an incremental query waiting for a Live subscriber
+type streamQuery struct {
+	qm    QueryModel
+	refID string
+	at    time.Time // when the query was registered
+}
+
+// SubscribeStream allows subscriptions to registered incremental queries.
+func (d *Datasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if _, ok := d.streams.Load(req.Path); !ok {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusNotFound,
+		}, nil
+	}
+	return &backend.SubscribeStreamResponse{
+		Status: backend.SubscribeStreamStatusOK,
+	}, nil
+}
+
+// PublishStream rejects publishing; incremental streams are read-only.
+func (d *Datasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{
+		Status: backend.PublishStreamStatusPermissionDenied,
+	}, nil
+}
+
+// RunStream executes an incremental query and sends a frame for every batch
+// of nodes as they are decoded from the Cortex stream. Each batch gets the
+// same display settings as a regular query and is conformed to the fields of
+// the first batch.
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	val, ok := d.streams.Load(req.Path)
+	if !ok {
+		return fmt.Errorf("unknown stream %s", req.Path)
+	}
+	sq := val.(streamQuery)
+	defer d.streams.Delete(req.Path)
+
+	ctx, cancel := d.withTimeout(ctx, d.config.QueryTimeout)
+	defer cancel()
+
+	reqBody, err := d.requestBody(sq.qm.StormQuery, sq.qm.Opts)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.post(ctx, "/api/v1/storm", reqBody)
+	if err != nil {
+		return fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storm query failed with status: %d", resp.StatusCode)
+	}
+
+	batchSize := sq.qm.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	// Buffer raw messages and hand each batch to the regular stream parser.
+	// Batches are parsed separately, so MaxNodes is enforced here across all
+	// of them.
+	var batch bytes.Buffer
+	batchNodes := 0
+	totalNodes := 0
+	schema := newStreamSchema()
+	flush := func(truncated bool) error {
+		if batchNodes == 0 {
+			return nil
+		}
+		frames, err := d.parseStormStream(&batch, sq.qm, sq.refID)
+		batch.Reset()
+		batchNodes = 0
+		if err != nil {
+			return err
+		}
+		for i, frame := range frames {
+			frames[i] = schema.conform(frame)
+		}
+		if truncated {
+			frames[0].AppendNotices(nodeLimitNotice(sq.qm))
+		}
+		d.decorateFrames(ctx, frames, sq.qm)
+		for _, frame := range frames {
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				return fmt.Errorf("send frame: %w", err)
+			}
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg []json.RawMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			// Send what was decoded before failing the stream
+			if ferr := flush(false); ferr != nil {
+				return ferr
+			}
+			return fmt.Errorf("decode storm stream: %w", err)
+		}
+		if len(msg) < 2 {
+			continue
+		}
+
+		var msgType string
+		if err := json.Unmarshal(msg[0], &msgType); err != nil {
+			continue
+		}
+		atLimit := sq.qm.MaxNodes > 0 && totalNodes >= sq.qm.MaxNodes
+		switch msgType {
+		case "node", "err":
+			if msgType == "node" && atLimit {
+				// The last batch is held at the limit so it can carry the notice
+				return flush(true)
+			}
+			raw, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			batch.Write(raw)
+			batch.WriteByte('\n')
+			batchNodes++
+			if msgType == "err" {
+				// Let the parser surface the storm error
+				return flush(false)
+			}
+			totalNodes++
+			if batchNodes >= batchSize && (sq.qm.MaxNodes <= 0 || totalNodes < sq.qm.MaxNodes) {
+				if err := flush(false); err != nil {
+					return err
+				}
+			}
+		case "fini":
+			return flush(false)
+		}
+	}
+
+	return flush(false)
+}
+
+// streamSchema holds the fields of the first batch of each frame of an
+// incremental query. Grafana Live cannot append frames whose fields change,
+// so later batches are conformed to these fields.
+type streamSchema struct {
+	frames  map[string]*data.Frame
+	dropped map[string]bool
+}
+
+func newStreamSchema() *streamSchema {
+	return &streamSchema{
+		frames:  make(map[string]*data.Frame),
+		dropped: make(map[string]bool),
+	}
+}
+
+// conform returns frame with the fields of the first batch of the same frame
+// name, all nullable so rows can leave them empty. Values of fields missing
+// from the batch or of a different type are left null, and fields new to the
+// batch are dropped, with a notice the first time each is seen.
+func (s *streamSchema) conform(frame *data.Frame) *data.Frame {
+	schema, ok := s.frames[frame.Name]
+	if !ok {
+		schema = data.NewFrame(frame.Name)
+		for _, field := range frame.Fields {
+			sf := data.NewFieldFromFieldType(field.Type().NullableType(), 0)
+			sf.Name = field.Name
+			sf.Labels = field.Labels
+			schema.Fields = append(schema.Fields, sf)
+		}
+		s.frames[frame.Name] = schema
+	}
+
+	rows := frame.Rows()
+	conformed := data.NewFrame(frame.Name)
+	conformed.RefID = frame.RefID
+	conformed.Meta = frame.Meta
+	used := make(map[*data.Field]bool, len(frame.Fields))
+	for _, sf := range schema.Fields {
+		field := data.NewFieldFromFieldType(sf.Type(), rows)
+		field.Name = sf.Name
+		field.Labels = sf.Labels
+		if src := streamField(frame, sf); src != nil {
+			used[src] = true
+			field.Config = src.Config
+			if src.Type().NullableType() == sf.Type() {
+				for i := 0; i < rows; i++ {
+					if val, ok := src.ConcreteAt(i); ok {
+						field.SetConcrete(i, val)
+					}
+				}
+			} else {
+				s.drop(conformed, src, "changed type after the first batch")
+			}
+		}
+		conformed.Fields = append(conformed.Fields, field)
+	}
+	for _, field := range frame.Fields {
+		if !used[field] {
+			s.drop(conformed, field, "appeared after the first batch")
+		}
+	}
+	return conformed
+}
+
+// drop notes on frame, once per field, that the values of field are not
+// streamed
+func (s *streamSchema) drop(frame *data.Frame, field *data.Field, reason string) {
+	key := frame.Name + "\x00" + field.Name + field.Labels.String()
+	if s.dropped[key] {
+		return
+	}
+	s.dropped[key] = true
+	frame.AppendNotices(data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("field %q %s and is not streamed", field.Name, reason),
+	})
+}
+
+// streamField returns the field of frame with the name and labels of sf
+func streamField(frame *data.Frame, sf *data.Field) *data.Field {
+	for _, field := range frame.Fields {
+		if field.Name == sf.Name && field.Labels.String() == sf.Labels.String() {
+			return field
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestStreamSchemaConform(t *testing.T) {
+	schema := newStreamSchema()
+
+	first := schema.conform(data.NewFrame("storm",
+		data.NewField("form", nil, []string{"inet:ipv4"}),
+		data.NewField("asn", nil, []int64{1}),
+	))
+	if first.Fields[0].Type() != data.FieldTypeNullableString || first.Fields[1].Type() != data.FieldTypeNullableInt64 {
+		t.Fatalf("first batch types: %s, %s", first.Fields[0].Type(), first.Fields[1].Type())
+	}
+
+	later := schema.conform(data.NewFrame("storm",
+		data.NewField("loc", nil, []string{"us"}),
+		data.NewField("form", nil, []string{"inet:fqdn"}),
+	))
+	if len(later.Fields) != 2 || later.Fields[0].Name != "form" || later.Fields[1].Name != "asn" {
+		t.Fatalf("later batch fields not conformed: %v", later.Fields)
+	}
+	if v, ok := later.Fields[0].ConcreteAt(0); !ok || v != "inet:fqdn" {
+		t.Errorf("form = %v, want inet:fqdn", v)
+	}
+	if _, ok := later.Fields[1].ConcreteAt(0); ok {
+		t.Errorf("missing asn should be null")
+	}
+	if later.Meta == nil || len(later.Meta.Notices) != 1 {
+		t.Fatalf("want one notice for the dropped loc field, got %v", later.Meta)
+	}
+
+	again := schema.conform(data.NewFrame("storm", data.NewField("loc", nil, []string{"us"})))
+	if again.Meta != nil && len(again.Meta.Notices) > 0 {
+		t.Errorf("dropped field noticed twice: %v", again.Meta.Notices)
+	}
+}
@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// viewByNameQuery returns the iden of the view named $name, or null
+const viewByNameQuery = `for $view in $lib.view.list() { if ($view.get(name) = $name) { return($view.iden) } }`
+
+// defaultView returns the view iden for queries that do not set opts.view:
+// DefaultView when set, otherwise DefaultViewName resolved to its iden. The
+// resolution is cached on the instance.
+func (d *Datasource) defaultView(ctx context.Context) (string, error) {
+	if d.config.DefaultView != "" || d.config.DefaultViewName == "" {
+		return d.config.DefaultView, nil
+	}
+
+	d.viewMu.Lock()
+	defer d.viewMu.Unlock()
+
+	if d.viewIden != "" {
+		return d.viewIden, nil
+	}
+	iden, err := d.resolveViewName(ctx, d.config.DefaultViewName)
+	if err != nil {
+		return "", err
+	}
+	d.viewIden = iden
+	return iden, nil
+}
+
+// invalidateDefaultView drops the cached view resolution so the next query
+// resolves DefaultViewName again
+func (d *Datasource) invalidateDefaultView() {
+	d.viewMu.Lock()
+	d.viewIden = ""
+	d.viewMu.Unlock()
+}
+
+// resolveViewName looks up the iden of the view named name
+func (d *Datasource) resolveViewName(ctx context.Context, name string) (string, error) {
+	reqBody, err := d.requestBody(viewByNameQuery, map[string]interface{}{"vars": map[string]interface{}{"name": name}})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := d.request(ctx, d.config.StormCallMethod, d.config.StormCallPath, reqBody)
+	if err != nil {
+		return "", fmt.Errorf("resolve view %q: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("resolve view %q: status: %d", name, resp.StatusCode)
+	}
+
+	var envelope struct {
+		Status string `json:"status"`
+		Mesg   string `json:"mesg"`
+		Result string `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return "", fmt.Errorf("resolve view %q: decode response: %w", name, err)
+	}
+	if envelope.Status != "ok" {
+		return "", fmt.Errorf("resolve view %q: %s", name, envelope.Mesg)
+	}
+	if envelope.Result == "" {
+		return "", fmt.Errorf("view %q not found", name)
+	}
+	return envelope.Result, nil
+}
+
+// isUnknownViewError reports whether err is the Cortex rejecting the
+// requested view
+func isUnknownViewError(err error) bool {
+	mesg := strings.ToLower(err.Error())
+	return strings.Contains(mesg, "nosuchview") || strings.Contains(mesg, "unknown view")
+}
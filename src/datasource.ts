@@ -1,14 +1,35 @@
 import {
   DataSourceInstanceSettings,
+  ScopedVars,
 } from '@grafana/data';
 
-import { DataSourceWithBackend } from '@grafana/runtime';
+import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
 
 import { SynapseCortexQuery, SynapseCortexDataSourceOptions } from './types';
 
+const VAR_TOKEN = /\$\{(\w+)\}/g;
+
 export class SynapseCortexDataSource extends DataSourceWithBackend<SynapseCortexQuery, SynapseCortexDataSourceOptions> {
   constructor(instanceSettings: DataSourceInstanceSettings<SynapseCortexDataSourceOptions>) {
     super(instanceSettings);
   }
 
+  applyTemplateVariables(query: SynapseCortexQuery, scopedVars: ScopedVars): SynapseCortexQuery {
+    if (!query.callArgs?.length) {
+      return query;
+    }
+
+    // Resolve ${var} tokens used in call args; the backend interpolates and types them
+    const resolved: Record<string, string> = {};
+    for (const arg of query.callArgs) {
+      if (typeof arg !== 'string') {
+        continue;
+      }
+      for (const match of arg.matchAll(VAR_TOKEN)) {
+        resolved[match[1]] = getTemplateSrv().replace(match[0], scopedVars);
+      }
+    }
+
+    return { ...query, scopedVars: resolved };
+  }
 }
@@ -4,6 +4,50 @@ export interface SynapseCortexQuery extends DataQuery {
   stormQuery: string;
   useCall?: boolean;
   opts?: Record<string, any>;
+  distinguishNull?: boolean;
+  includeRawNode?: boolean;
+  colorTagPrefix?: string;
+  useMirror?: boolean;
+  singleJSONColumn?: boolean;
+  callArgs?: any[];
+  scopedVars?: Record<string, string>;
+  messageStats?: boolean;
+  maxNodes?: number;
+  propsAsJSON?: boolean;
+  savedQueryName?: string;
+  fieldTypes?: Record<string, 'string' | 'int' | 'float' | 'bool' | 'time'>;
+  decimals?: Record<string, number>;
+  creationRate?: boolean;
+  tagRatePrefix?: string;
+  includeLayer?: boolean;
+  includeProvenance?: boolean;
+  tableColumns?: string[];
+  withEdges?: boolean;
+  logsMode?: boolean;
+  computedFields?: Record<string, string>;
+  incremental?: boolean;
+  batchSize?: number;
+  countOnly?: boolean;
+  formLabels?: boolean;
+  partitionBy?: string;
+  includeNdef?: boolean;
+  topN?: { prop: string; count: number };
+  heatmapMode?: boolean;
+  diffMode?: boolean;
+  editSummary?: boolean;
+  tagTree?: boolean;
+  multiFrameKey?: string;
+  varTypes?: Record<string, string>;
+  explain?: boolean;
+  minPropCoverage?: number;
+  timeoutMs?: number;
+  maxRows?: number;
+  dryRun?: boolean;
+  ensureIden?: boolean;
+  tagsAsLabels?: boolean;
+  valueAsNumber?: boolean;
+  postFilters?: Array<{ field: string; op: 'eq' | 'neq' | 'gt' | 'lt' | 'contains' | 'regex'; value: string }>;
+  boolAsLabel?: boolean;
 }
 
 export const DEFAULT_QUERY: Partial<SynapseCortexQuery> = {
@@ -17,10 +61,61 @@ export interface SynapseCortexDataSourceOptions extends DataSourceJsonData {
   version?: string;
   timeout?: number;
   tlsSkipVerify?: boolean;
+  requestContentType?: string;
+  requestBodyTemplate?: string;
+  acceptContentType?: string;
+  queryTimeout?: number;
+  healthTimeout?: number;
+  resourceTimeout?: number;
+  autoTimeField?: boolean;
+  fieldDisplayNames?: Record<string, string>;
+  ahaUrl?: string;
+  cortexName?: string;
+  oauth2TokenUrl?: string;
+  oauth2ClientId?: string;
+  oauth2Scopes?: string[];
+  nullValue?: string;
+  savedQueries?: Record<string, string>;
+  defaultView?: string;
+  defaultViewName?: string;
+  allowFeed?: boolean;
+  fallbackUrls?: string[];
+  maxQueryLength?: number;
+  compressRequests?: boolean;
+  maxRetries?: number;
+  maxRetryWait?: number;
+  defaultDecimals?: number;
+  boolLabels?: [string, string];
+  valueFormatters?: Record<string, string>;
+  formDisplayNames?: Record<string, string>;
+  statusKey?: string;
+  resultKey?: string;
+  messageKey?: string;
+  timeUnit?: 'auto' | 'ms' | 's' | 'us';
+  streamIdleTimeout?: number;
+  softErrors?: boolean;
+  failOnDeprecation?: boolean;
+  dedupeQueries?: boolean;
+  userAgent?: string;
+  globalMaxNodes?: number;
+  modelMetadata?: boolean;
+  prettyJSON?: boolean;
+  userScopeVar?: string;
+  userScopeClaim?: 'login' | 'email' | 'name' | 'role';
+  maxObjectKeys?: number;
+  stormCallPath?: string;
+  stormCallMethod?: string;
+  environmentVars?: Record<string, any>;
+  healthCacheTTL?: number;
+  durationUnit?: string;
+  tagLabelReplacement?: string;
+  timeVarPrefix?: string;
+  maxTagsPerNode?: number;
 }
 
 export interface SynapseCortexSecureJsonData {
   apiKey?: string;
+  oauth2ClientSecret?: string;
 }
 
 // API Response Types